@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// CONFIG_FILES are the configs looked for, in order, when -config is not given.
+var CONFIG_FILES = []string{"persist-config.json", "persist-config.yaml", "persist-config.yml", "persist-config.toml"}
+
+// findConfig returns the first config found in the working directory or in config/.
+func findConfig() (string, error) {
+	for _, dir := range []string{".", "config"} {
+		for _, file := range CONFIG_FILES {
+			if _, err := os.Stat(path.Join(dir, file)); err == nil {
+				return path.Join(dir, file), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no config found, expected one of %v", strings.Join(CONFIG_FILES, ", "))
+}
+
+// VARIABLE_REGEX matches the ${VAR} and ${VAR:-default} references of config strings.
+var VARIABLE_REGEX = regexp.MustCompile(`\$\{(\w+)(:-([^}]*))?\}`)
+var VARIABLE_NAME_REGEX = regexp.MustCompile(`^\w+$`)
+
+// variables are set with -set and take precedence over the environment.
+type variables map[string]string
+
+func (v variables) String() string {
+	res := make([]string, 0, len(v))
+	for key, value := range v {
+		res = append(res, key+"="+value)
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+func (v variables) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || !VARIABLE_NAME_REGEX.MatchString(key) {
+		return fmt.Errorf("expected key=value, got %v", s)
+	}
+	v[key] = value
+	return nil
+}
+
+var configVariables = variables{}
+
+func init() {
+	flag.Var(configVariables, "set", "define a `key=value` variable the config references as ${key}, overrides the environment and may be repeated")
+}
+
+// interpolate expands the variables referenced by a config string.
+func interpolate(value string) (string, error) {
+	var err error
+	res := VARIABLE_REGEX.ReplaceAllStringFunc(value, func(ref string) string {
+		match := VARIABLE_REGEX.FindStringSubmatch(ref)
+		if v, ok := configVariables[match[1]]; ok {
+			return v
+		} else if v, ok := os.LookupEnv(match[1]); ok && (v != "" || match[2] == "") {
+			return v
+		} else if match[2] != "" {
+			return match[3]
+		}
+		err = fmt.Errorf("variable %v of %v is not set", match[1], value)
+		return ref
+	})
+	return res, err
+}
+
+// interpolateValues expands the variables of every string of a decoded config.
+func interpolateValues(value interface{}) (interface{}, error) {
+	var err error
+	switch v := value.(type) {
+	case string:
+		return interpolate(v)
+	case map[string]interface{}:
+		for key, child := range v {
+			if v[key], err = interpolateValues(child); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			if v[i], err = interpolateValues(child); err != nil {
+				return nil, err
+			}
+		}
+	case []map[string]interface{}:
+		for _, child := range v {
+			if _, err = interpolateValues(child); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
+
+// readConfig decodes a config, picking its format from the file extension. Every
+// format is decoded generically and converted to JSON so they share the json tags,
+// expanding the variables of its strings first when asked to.
+func readConfig(file string, expand bool) (*persistDef, error) {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		if err := json.Unmarshal(source, &raw); err != nil {
+			return nil, fmt.Errorf("could not read %v: %v", file, err)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(source, &raw); err != nil {
+			return nil, fmt.Errorf("could not read %v: %v", file, err)
+		}
+	case ".toml":
+		if err := toml.Unmarshal(source, &raw); err != nil {
+			return nil, fmt.Errorf("could not read %v: %v", file, err)
+		}
+	default:
+		return nil, fmt.Errorf("config %v must be a .json, .yaml, .yml or .toml file", file)
+	}
+
+	if expand {
+		if _, err := interpolateValues(raw); err != nil {
+			return nil, fmt.Errorf("could not read %v: %v", file, err)
+		}
+	}
+	if source, err = json.Marshal(raw); err != nil {
+		return nil, fmt.Errorf("could not read %v: %v", file, err)
+	}
+
+	persist := &persistDef{}
+	if err := json.NewDecoder(bytes.NewReader(source)).Decode(persist); err != nil {
+		return nil, fmt.Errorf("could not read %v: %v", file, err)
+	}
+	return persist, nil
+}
+
+// writeConfig encodes a config in the format picked by the file extension.
+func writeConfig(file string, persist *persistDef) error {
+	var res bytes.Buffer
+	encoder := json.NewEncoder(&res)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(persist); err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		// YAML reads JSON, decoding into a node keeps the order of the keys.
+		var node yaml.Node
+		if err := yaml.Unmarshal(res.Bytes(), &node); err != nil {
+			return err
+		}
+		blockStyle(&node)
+
+		out, err := yaml.Marshal(&node)
+		if err != nil {
+			return err
+		}
+		res.Reset()
+		res.Write(out)
+	case ".toml":
+		var raw map[string]interface{}
+		if err := json.Unmarshal(res.Bytes(), &raw); err != nil {
+			return err
+		}
+		res.Reset()
+		if err := toml.NewEncoder(&res).Encode(raw); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(file, res.Bytes(), 0644)
+}
+
+func blockStyle(node *yaml.Node) {
+	node.Style &^= yaml.FlowStyle | yaml.DoubleQuotedStyle
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+type schemaHint struct {
+	Description string
+	Enum        []string
+	AnyOf       []map[string]interface{}
+}
+
+// CQL_TYPE_NAMES are the scalar cql types columns may declare.
+var CQL_TYPE_NAMES = []string{
+	"ascii", "bigint", "blob", "boolean", "counter", "date", "double", "float", "inet", "int",
+	"smallint", "text", "timestamp", "timeuuid", "tinyint", "uuid", "varchar",
+}
+
+var cqlTypeHint = schemaHint{
+	Description: "cql type of the column, a scalar, a collection such as list<text> or map<text, blob>, or a user defined type",
+	AnyOf: []map[string]interface{}{
+		{"enum": CQL_TYPE_NAMES},
+		{"pattern": `^(list|set|frozen)<.+>$`},
+		{"pattern": `^map<.+,.+>$`},
+		{"pattern": `^[A-Za-z_][\w.]*$`},
+	},
+}
+
+// SCHEMA_HINTS describe config values beyond their Go type, keyed by struct and json name.
+var SCHEMA_HINTS = map[string]schemaHint{
+	"persistDef.keyspace":                 {Description: "keyspace the tables are created in"},
+	"persistDef.package":                  {Description: "package of the generated DAOs"},
+	"persistDef.boilerplate":              {Description: "template file injected in the DAO files of the config's tables, an included config's own applies to its tables"},
+	"persistDef.imports":                  {Description: "additional imports of the generated DAO files, such as \"\\\"example.com/model\\\"\""},
+	"persistDef.modelPackage":             {Description: "package qualifying the model types in the DAOs"},
+	"persistDef.ModelGeneration":          {Description: "generate the model structs into another package"},
+	"persistDef.types":                    {Description: "user defined types the columns may use"},
+	"persistDef.cql":                      {Description: "directory of .cql files declaring tables and annotated queries, relative to the config"},
+	"persistDef.models":                   {Description: "directory of Go structs with cql tags to generate DAOs for, relative to the config"},
+	"persistDef.location":                 {Description: "directory the DAOs are written to relative to the config, the working directory by default or the directory of an included config"},
+	"persistDef.include":                  {Description: "configs whose tables and types are generated too, their settings apply to their own tables and their paths are relative to them"},
+	"persistDef.runtimeKeyspace":          {Description: "pick the keyspace when the DAOs are constructed, each DAO embeds the <Model>Statements made by New<Model>Statements"},
+	"tableDef.runtimeKeyspace":            {Description: "pick the table's keyspace when its DAO is constructed"},
+	"tableDef.tableOptions":               {Description: "options of the table's CREATE TABLE statement"},
+	"tableOptionsDef.compaction":          {Description: "compaction strategy, a class of STCS, LCS, TWCS or a strategy class name followed by its sub-options"},
+	"tableOptionsDef.compression":         {Description: "compression of the sstables, such as {class: LZ4Compressor, chunk_length_in_kb: 64}"},
+	"tableOptionsDef.caching":             {Description: "caching of the keys, ALL or NONE, and of the rows_per_partition, ALL, NONE or a number"},
+	"tableOptionsDef.gcGraceSeconds":      {Description: "seconds tombstones are kept before they are collected"},
+	"tableOptionsDef.bloomFilterFpChance": {Description: "false positive chance of the sstable bloom filters"},
+	"tableOptionsDef.defaultTimeToLive":   {Description: "seconds rows live when written without a TTL"},
+	"tableOptionsDef.comment":             {Description: "comment of the table"},
+	"persistDef.keyspaceOptions":          {Description: "replication of the keyspace, generates InitKeyspace on the DAOs"},
+	"keyspaceDef.durableWrites":           {Description: "whether writes to the keyspace go through the commit log, true unless declared"},
+	"tableDef.keyspaceOptions":            {Description: "replication of the table's keyspace, generates InitKeyspace on its DAO"},
+	"replicationDef.class":                {Description: "replication strategy", Enum: []string{"SimpleStrategy", "NetworkTopologyStrategy"}},
+	"replicationDef.replicationFactor":    {Description: "replicas of each row, for SimpleStrategy"},
+	"replicationDef.dataCenters":          {Description: "replicas of each row in each datacenter, for NetworkTopologyStrategy"},
+	"tableDef.keyspace":                   {Description: "keyspace of the table, overriding the config's"},
+	"tableDef.package":                    {Description: "package of the table's DAO, overriding the config's"},
+	"tableDef.location":                   {Description: "directory the table's DAO is written to, overriding the config's"},
+	"tableDef.modelName":                  {Description: "name of the model struct"},
+	"tableDef.tableName":                  {Description: "name of the cql table"},
+	"tableDef.dao":                        {Description: "DAO type the methods are generated on, declared by hand with createSession, capacity and pageSize"},
+	"tableDef.generatedName":              {Description: "prefix of the generated file names"},
+	"tableDef.views":                      {Description: "materialized views of the table created by Init, each read through a DAO of its own"},
+	"viewDef.name":                        {Description: "name of the cql view"},
+	"viewDef.dao":                         {Description: "DAO type the read methods of the view are generated on, declared like the table's"},
+	"viewDef.generatedName":               {Description: "prefix of the generated file name, the view name by default"},
+	"viewDef.keys":                        {Description: "primary key of the view, every primary key column of the table and at most one other column"},
+	"viewKeyDef.key":                      {Description: "part of the view's primary key the column belongs to", Enum: []string{"partition", "cluster", "cluster-asc", "cluster-desc"}},
+	"columnDef.name":                      {Description: "cql name of the column"},
+	"columnDef.field":                     {Description: "model field of the column, defaults to the column name, or its Go name for tables read from cql or models"},
+	"columnDef.type":                      cqlTypeHint,
+	"columnDef.key": {
+		Description: "part of the primary key the column belongs to",
+		Enum:        []string{"partition", "cluster", "cluster-asc", "cluster-desc", "static"},
+	},
+	"columnDef.deserializeTo": {Description: "Go type the blobs of the column hold, such as *model.Event"},
+	"columnDef.serializer": {
+		Description: "encoding of the blobs, json by default or a composite literal of a type with Marshal and Unmarshal methods",
+		AnyOf: []map[string]interface{}{
+			{"enum": []string{"json", "gob", "protobuf", "msgpack"}},
+			{"pattern": `^[\w.*]+\{.*\}$`},
+		},
+	},
+	"columnDef.serializationErrors": {
+		Description: "how blobs that can not be decoded are reported",
+		Enum:        []string{"log", "strict", "lenient"},
+	},
+	"columnDef.index":      {Description: "index created by Init, generating ListBy<Column> and StreamBy<Column>", Enum: []string{"secondary", "sai"}},
+	"columnDef.indexName":  {Description: "name of the column's index, <table>_<column>_idx by default"},
+	"queryDef.name":        {Description: "name of the generated method"},
+	"queryDef.where":       {Description: "clause following FROM <table> of a one or many query"},
+	"queryDef.cql":         {Description: "statement of an exec query, {table} names the qualified table"},
+	"queryDef.cardinality": {Description: "rows returned by the query", Enum: []string{"one", "many", "exec"}},
+	"queryParamDef.type":   cqlTypeHint,
+}
+
+// writeSchema prints the JSON Schema of the config, derived from its json tags.
+func writeSchema(w io.Writer) error {
+	defs := make(map[string]interface{})
+	schema := map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "gocql-gen config",
+	}
+	for k, v := range jsonSchema(reflect.TypeOf(persistDef{}), defs) {
+		schema[k] = v
+	}
+	delete(defs, "persistDef")
+	schema["$defs"] = defs
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(schema)
+}
+
+func jsonSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem(), defs)
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem(), defs)}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Struct:
+	default:
+		return map[string]interface{}{}
+	}
+
+	if _, ok := defs[t.Name()]; !ok {
+		properties := make(map[string]interface{})
+		def := map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+		defs[t.Name()] = def
+
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := strings.Split(f.Tag.Get("json"), ",")
+			if !f.IsExported() || tag[0] == "-" {
+				continue
+			}
+
+			name := tag[0]
+			if name == "" {
+				name = f.Name
+			}
+
+			property := jsonSchema(f.Type, defs)
+			if hint, ok := SCHEMA_HINTS[t.Name()+"."+name]; ok {
+				property["description"] = hint.Description
+				if hint.Enum != nil {
+					property["enum"] = hint.Enum
+				} else if hint.AnyOf != nil {
+					property["anyOf"] = hint.AnyOf
+				}
+			}
+			properties[name] = property
+		}
+	}
+
+	if t == reflect.TypeOf(persistDef{}) {
+		return defs[t.Name()].(map[string]interface{})
+	}
+	return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+}
+
+// includeConfigs merges the tables and types of the configs persist includes. The
+// settings of an included config become the defaults of its own tables, and its
+// relative paths resolve against its directory. A config included along several
+// paths is merged once.
+func includeConfigs(persist *persistDef, dir string, seen map[string]bool) {
+	for _, include := range persist.Include {
+		file := resolvePath(dir, include)
+		if abs, err := filepath.Abs(file); err != nil {
+			log.Fatalf("Could not resolve %v: %v", file, err)
+		} else if seen[abs] {
+			continue
+		} else {
+			seen[abs] = true
+		}
+
+		child, err := readConfig(file, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		childDir := path.Dir(file)
+		if resolvePaths(child, childDir); child.Location == "" {
+			child.Location = childDir
+		}
+
+		if child.CQL != "" {
+			loadCQL(child.CQL, child)
+		}
+		if child.Models != "" {
+			loadModels(child.Models, child.Location, child)
+		}
+
+		for _, table := range child.Tables {
+			table.inherit(child)
+		}
+		includeConfigs(child, childDir, seen)
+
+		for _, table := range child.Tables {
+			for _, existing := range persist.Tables {
+				if strings.EqualFold(existing.Table, table.Table) && strings.EqualFold(existing.Keyspace, table.Keyspace) {
+					log.Fatalf("Table %v.%v of %v is already defined", table.Keyspace, table.Table, file)
+				}
+			}
+			persist.Tables = append(persist.Tables, table)
+		}
+
+		for _, udt := range child.Types {
+			if udt.generation == nil {
+				udt.generation = child.ModelGeneration
+			}
+			mergeType(persist, udt)
+		}
+	}
+}
+
+// resolvePaths resolves the relative paths of a config against the directory of its
+// file. The location of a config stays the working directory when it declares none.
+func resolvePaths(persist *persistDef, dir string) {
+	for _, p := range []*string{&persist.Location, &persist.CQL, &persist.Models, &persist.BoilerPlate} {
+		if *p != "" {
+			*p = resolvePath(dir, *p)
+		}
+	}
+	if persist.ModelGeneration != nil {
+		persist.ModelGeneration = &modelDef{Package: persist.ModelGeneration.Package, Location: resolvePath(dir, persist.ModelGeneration.Location)}
+	}
+
+	for _, table := range persist.Tables {
+		if table.Location != "" {
+			table.Location = resolvePath(dir, table.Location)
+		}
+		if table.ModelGeneration != nil {
+			table.ModelGeneration = &modelDef{Package: table.ModelGeneration.Package, Location: resolvePath(dir, table.ModelGeneration.Location)}
+		}
+	}
+}
+
+func resolvePath(dir, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return path.Join(dir, p)
+}
+
+// inherit fills the settings a table does not override from the config declaring it.
+func (t *tableDef) inherit(persist *persistDef) {
+	if t.KeyspaceOptions == nil && (t.Keyspace == "" || t.Keyspace == persist.Keyspace) {
+		t.KeyspaceOptions = persist.KeyspaceOptions
+	}
+	if t.Keyspace == "" {
+		t.Keyspace = persist.Keyspace
+	}
+	if t.Package == "" {
+		t.Package = persist.Package
+	}
+	if t.AdditionalImports == nil {
+		t.AdditionalImports = persist.AdditionalImports
+	}
+	if t.ModelImport == "" {
+		t.ModelImport = persist.ModelImport
+	}
+	if t.ModelGeneration == nil {
+		t.ModelGeneration = persist.ModelGeneration
+	}
+	if t.Location == "" {
+		t.Location = persist.Location
+	}
+	if t.boilerPlate == "" {
+		t.boilerPlate = persist.BoilerPlate
+	}
+	t.RuntimeKeyspace = t.RuntimeKeyspace || persist.RuntimeKeyspace
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestIncludeConfigs(t *testing.T) {
+	dir := t.TempDir()
+	for file, config := range map[string]string{
+		"persist-config.json": `{"keyspace": "ks", "package": "root", "cql": "schema", "include": ["a/persist-config.json", "b/persist-config.json"], "tables": []}`,
+		"a/persist-config.json": `{"keyspace": "ks", "package": "a", "boilerplate": "a.tmpl", "include": ["../shared/persist-config.json"],
+			"tables": [{"modelName": "A", "tableName": "a", "dao": "ADAO", "columns": [{"name": "id", "type": "uuid", "key": "partition"}]}]}`,
+		"b/persist-config.json":      `{"keyspace": "ks", "package": "b", "include": ["../shared/persist-config.json"], "tables": []}`,
+		"shared/persist-config.json": `{"keyspace": "ks", "package": "shared", "tables": [{"modelName": "S", "tableName": "s", "dao": "SDAO", "columns": [{"name": "id", "type": "uuid", "key": "partition"}]}]}`,
+	} {
+		if err := os.MkdirAll(path.Dir(path.Join(dir, file)), 0755); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(path.Join(dir, file), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file := path.Join(dir, "persist-config.json")
+	persist, err := readConfig(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolvePaths(persist, dir)
+	if persist.CQL != path.Join(dir, "schema") {
+		t.Errorf("cql resolved to %v, expected it next to the config", persist.CQL)
+	}
+
+	includeConfigs(persist, dir, map[string]bool{file: true})
+	tables := make(map[string]*tableDef)
+	for _, table := range persist.Tables {
+		if tables[table.Table] != nil {
+			t.Errorf("table %v was included twice", table.Table)
+		}
+		tables[table.Table] = table
+	}
+	if len(tables) != 2 || tables["a"] == nil || tables["s"] == nil {
+		t.Fatalf("included tables are %v", persist.Tables)
+	}
+
+	if expected := path.Join(dir, "a", "a.tmpl"); tables["a"].boilerPlate != expected {
+		t.Errorf("a has boilerplate %q, expected %q", tables["a"].boilerPlate, expected)
+	} else if tables["s"].boilerPlate != "" {
+		t.Errorf("s took the boilerplate %q of the config including it", tables["s"].boilerPlate)
+	}
+	if tables["s"].Location != path.Join(dir, "shared") || tables["s"].Package != "shared" {
+		t.Errorf("s is generated into %v as package %v", tables["s"].Location, tables["s"].Package)
+	}
+}
+
+func TestOptionKeys(t *testing.T) {
+	var persist persistDef
+	if err := json.Unmarshal([]byte(`{"keyspaceOptions": {"replication": {"class": "SimpleStrategy", "replicationFactor": 3}, "durableWrites": false},
+		"tables": [{"tableOptions": {"gcGraceSeconds": 3600, "bloomFilterFpChance": 0.1, "defaultTimeToLive": 60, "compaction": {"class": "LCS", "enabled": true, "sstable_size_in_mb": 160}}}]}`), &persist); err != nil {
+		t.Fatal(err)
+	}
+
+	if definition, expected := persist.KeyspaceOptions.definition("ks"), "CREATE KEYSPACE IF NOT EXISTS ks WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 3} AND durable_writes = false;"; definition != expected {
+		t.Errorf("keyspace is %v, expected %v", definition, expected)
+	}
+
+	options := persist.Tables[0].TableOptions
+	options.validate("t")
+	if clauses, expected := options.clauses(), []string{
+		"compaction = {'class': 'LeveledCompactionStrategy', 'enabled': true, 'sstable_size_in_mb': 160}",
+		"gc_grace_seconds = 3600",
+		"bloom_filter_fp_chance = 0.1",
+		"default_time_to_live = 60",
+	}; !reflect.DeepEqual(clauses, expected) {
+		t.Errorf("options are\n  %v\nexpected\n  %v", clauses, expected)
+	}
+}
@@ -0,0 +1,893 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var QUERY_ANNOTATION_REGEX = regexp.MustCompile(`^--\s*name:\s*(\w+)\s+:(one|many|exec)\s*$`)
+
+type cqlToken struct {
+	Text  string
+	Start int
+	End   int
+}
+
+type cqlStatement struct {
+	File        string
+	Text        string
+	Tokens      []cqlToken
+	Name        string
+	Cardinality string
+}
+
+// upper returns the upper cased text of the i-th token, or "" past the end of the statement.
+func (s *cqlStatement) upper(i int) string {
+	if i < 0 || i >= len(s.Tokens) {
+		return ""
+	}
+	return strings.ToUpper(s.Tokens[i].Text)
+}
+
+// text returns the source of the statement from the start of the i-th token to the end.
+func (s *cqlStatement) text(i int) string {
+	if i >= len(s.Tokens) {
+		return ""
+	}
+	return strings.TrimSpace(s.Text[s.Tokens[i].Start:])
+}
+
+// parseCQL splits a cql source into statements. A "-- name: <Name> :<one|many|exec>"
+// comment names the statement that follows it.
+func parseCQL(file, source string) []*cqlStatement {
+	statements := make([]*cqlStatement, 0)
+	current := &cqlStatement{File: file}
+	start := -1
+
+	finish := func(end int) {
+		if len(current.Tokens) > 0 {
+			current.Text = source[start:end]
+			for i := range current.Tokens {
+				current.Tokens[i].Start -= start
+				current.Tokens[i].End -= start
+			}
+			statements = append(statements, current)
+		} else if current.Name != "" {
+			log.Fatalf("Query %v in %v was not followed by a statement", current.Name, file)
+		}
+		current, start = &cqlStatement{File: file}, -1
+	}
+
+	add := func(from, to int) {
+		if start == -1 {
+			start = from
+		}
+		current.Tokens = append(current.Tokens, cqlToken{Text: source[from:to], Start: from, End: to})
+	}
+
+	for i := 0; i < len(source); {
+		r, n := utf8.DecodeRuneInString(source[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += n
+		case strings.HasPrefix(source[i:], "--") || strings.HasPrefix(source[i:], "//"):
+			end := strings.IndexByte(source[i:], '\n')
+			if end == -1 {
+				end = len(source) - i
+			}
+			if match := QUERY_ANNOTATION_REGEX.FindStringSubmatch(strings.TrimSpace(source[i : i+end])); match != nil {
+				if len(current.Tokens) > 0 {
+					log.Fatalf("Query %v in %v starts inside a statement, is a ';' missing?", match[1], file)
+				}
+				current.Name, current.Cardinality = match[1], match[2]
+			}
+			i += end
+		case strings.HasPrefix(source[i:], "/*"):
+			end := strings.Index(source[i:], "*/")
+			if end == -1 {
+				log.Fatalf("Unterminated comment in %v", file)
+			}
+			i += end + 2
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(source) {
+				if source[end] == byte(r) {
+					if end+1 < len(source) && source[end+1] == byte(r) {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			if end >= len(source) {
+				log.Fatalf("Unterminated quote in %v", file)
+			}
+			add(i, end+1)
+			i = end + 1
+		case strings.HasPrefix(source[i:], "$$"):
+			end := strings.Index(source[i+2:], "$$")
+			if end == -1 {
+				log.Fatalf("Unterminated $$ string in %v", file)
+			}
+			add(i, i+end+4)
+			i += end + 4
+		case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			end := i
+			for end < len(source) {
+				r, n := utf8.DecodeRuneInString(source[end:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				end += n
+			}
+			add(i, end)
+			i = end
+		case r == ';':
+			finish(i)
+			i += n
+		default:
+			end := i + n
+			if op := source[i:min(i+2, len(source))]; op == "<=" || op == ">=" || op == "!=" {
+				end = i + 2
+			}
+			add(i, end)
+			i = end
+		}
+	}
+	finish(len(source))
+	return statements
+}
+
+// splitTopLevel splits tokens on commas that are not nested in parentheses or
+// angle brackets.
+func splitTopLevel(tokens []cqlToken) [][]cqlToken {
+	parts := make([][]cqlToken, 0)
+	depth, from := 0, 0
+	for i, t := range tokens {
+		switch t.Text {
+		case "(", "<":
+			depth++
+		case ")", ">":
+			depth--
+		case ",":
+			if depth == 0 {
+				parts = append(parts, tokens[from:i])
+				from = i + 1
+			}
+		}
+	}
+	return append(parts, tokens[from:])
+}
+
+// closing returns the index of the parenthesis closing the one at open.
+func closing(tokens []cqlToken, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		switch tokens[i].Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func joinTokens(tokens []cqlToken) string {
+	var res strings.Builder
+	for _, t := range tokens {
+		res.WriteString(t.Text)
+	}
+	return res.String()
+}
+
+// typeText joins the tokens of a cql type lower cased, but for the quoted names of
+// user defined types which are case sensitive.
+func typeText(tokens []cqlToken) string {
+	var res strings.Builder
+	for _, t := range tokens {
+		if strings.HasPrefix(t.Text, `"`) {
+			res.WriteString(t.Text)
+		} else {
+			res.WriteString(strings.ToLower(t.Text))
+		}
+	}
+	return res.String()
+}
+
+// unquote returns the text of a cql string literal.
+func unquote(text string) string {
+	if len(text) >= 2 && strings.HasPrefix(text, "'") && strings.HasSuffix(text, "'") {
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1)
+	}
+	return text
+}
+
+// qualifiedName reads a possibly keyspace qualified name starting at the i-th
+// token and returns the keyspace, the name and the index following it.
+func (s *cqlStatement) qualifiedName(i int) (string, string, int) {
+	if i+2 < len(s.Tokens) && s.Tokens[i+1].Text == "." {
+		return s.Tokens[i].Text, s.Tokens[i+2].Text, i + 3
+	} else if i < len(s.Tokens) {
+		return "", s.Tokens[i].Text, i + 1
+	}
+	log.Fatalf("Expected a name at the end of %v in %v", s.Text, s.File)
+	return "", "", i
+}
+
+// skipIfNotExists returns the index following an optional IF NOT EXISTS at i.
+func (s *cqlStatement) skipIfNotExists(i int) int {
+	if s.upper(i) == "IF" && s.upper(i+1) == "NOT" && s.upper(i+2) == "EXISTS" {
+		return i + 3
+	}
+	return i
+}
+
+type cqlTable struct {
+	Keyspace string
+	Table    *tableDef
+}
+
+// parseCreateTable builds a table definition from a CREATE TABLE statement. Key
+// columns are ordered as in the primary key since that order drives the DAO.
+func parseCreateTable(s *cqlStatement) *cqlTable {
+	keyspace, name, i := s.qualifiedName(s.skipIfNotExists(2))
+	if s.upper(i) != "(" {
+		log.Fatalf("Expected the column definitions of %v in %v", name, s.File)
+	}
+	end := closing(s.Tokens, i)
+	if end == -1 {
+		log.Fatalf("Unbalanced parenthesis in the definition of %v in %v", name, s.File)
+	}
+
+	columns := make([]*columnDef, 0)
+	var partition, clustering []string
+	for _, def := range splitTopLevel(s.Tokens[i+1 : end]) {
+		if len(def) == 0 {
+			continue
+		} else if strings.EqualFold(def[0].Text, "PRIMARY") {
+			if len(def) < 4 || !strings.EqualFold(def[1].Text, "KEY") || def[2].Text != "(" {
+				log.Fatalf("Could not read the primary key of %v in %v", name, s.File)
+			}
+
+			keys := splitTopLevel(def[3 : len(def)-1])
+			if len(keys[0]) > 0 && keys[0][0].Text == "(" {
+				for _, k := range splitTopLevel(keys[0][1 : len(keys[0])-1]) {
+					partition = append(partition, joinTokens(k))
+				}
+			} else {
+				partition = append(partition, joinTokens(keys[0]))
+			}
+
+			for _, k := range keys[1:] {
+				clustering = append(clustering, joinTokens(k))
+			}
+			continue
+		}
+
+		column := &columnDef{Name: def[0].Text, Field: goName(def[0].Text)}
+		typeEnd := len(def)
+		for j := 1; j < len(def); j++ {
+			if upper := strings.ToUpper(def[j].Text); upper == "PRIMARY" || upper == "STATIC" {
+				typeEnd = j
+				if upper == "PRIMARY" {
+					partition = append(partition, column.Name)
+				} else {
+					column.Key = "static"
+				}
+				break
+			}
+		}
+		column.CqlType = typeText(def[1:typeEnd])
+		columns = append(columns, column)
+	}
+
+	order := make(map[string]string)
+	for j := end + 1; j < len(s.Tokens); j++ {
+		if s.upper(j) == "CLUSTERING" && s.upper(j+1) == "ORDER" && s.upper(j+2) == "BY" && s.upper(j+3) == "(" {
+			for _, o := range splitTopLevel(s.Tokens[j+4 : closing(s.Tokens, j+3)]) {
+				if len(o) == 2 {
+					order[strings.ToLower(o[0].Text)] = "cluster-" + strings.ToLower(o[1].Text)
+				}
+			}
+		}
+	}
+
+	find := func(key string) *columnDef {
+		for _, c := range columns {
+			if strings.EqualFold(c.Name, key) {
+				return c
+			}
+		}
+		log.Fatalf("Primary key column %v is not a column of %v in %v", key, name, s.File)
+		return nil
+	}
+
+	ordered := make([]*columnDef, 0, len(columns))
+	for _, k := range partition {
+		c := find(k)
+		c.Key = "partition"
+		ordered = append(ordered, c)
+	}
+	for _, k := range clustering {
+		c := find(k)
+		c.Key = "cluster"
+		if o, ok := order[strings.ToLower(k)]; ok {
+			c.Key = o
+		}
+		ordered = append(ordered, c)
+	}
+	for _, c := range columns {
+		if c.Key == "" || c.Key == "static" {
+			ordered = append(ordered, c)
+		}
+	}
+
+	if len(partition) == 0 {
+		log.Fatalf("Table %v in %v has no primary key", name, s.File)
+	}
+
+	model := goName(name)
+	return &cqlTable{
+		Keyspace: keyspace,
+		Table: &tableDef{
+			Model:         model,
+			Table:         name,
+			DAO:           model + "DAO",
+			GeneratedName: name,
+			Columns:       ordered,
+			TableOptions:  parseTableOptions(s, name, end+1),
+		},
+	}
+}
+
+// parseTableOptions reads the WITH options following the column definitions of a
+// CREATE TABLE. DESCRIBE lists every option, those the config has no field for are
+// reported and left out.
+func parseTableOptions(s *cqlStatement, name string, with int) *tableOptionsDef {
+	if s.upper(with) != "WITH" {
+		return nil
+	}
+
+	options, skipped, from := &tableOptionsDef{}, make([]string, 0), with+1
+	for j := from; j <= len(s.Tokens); j++ {
+		if j < len(s.Tokens) && s.upper(j) != "AND" {
+			continue
+		}
+		clause := s.Tokens[from:j]
+		from = j + 1
+		if len(clause) > 0 && strings.EqualFold(clause[0].Text, "CLUSTERING") {
+			continue
+		} else if len(clause) < 3 || clause[1].Text != "=" {
+			log.Fatalf("Could not read the options of table %v in %v", name, s.File)
+		}
+
+		key, value := strings.ToLower(clause[0].Text), joinTokens(clause[2:])
+		number := func() float64 {
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				log.Fatalf("Table %v option %v is not a number in %v", name, key, s.File)
+			}
+			return n
+		}
+		switch key {
+		case "compaction":
+			options.Compaction = parseOptionMap(s, name, clause[2:])
+		case "compression":
+			options.Compression = parseOptionMap(s, name, clause[2:])
+		case "caching":
+			options.Caching = parseOptionMap(s, name, clause[2:])
+		case "gc_grace_seconds":
+			n := int(number())
+			options.GcGraceSeconds = &n
+		case "default_time_to_live":
+			n := int(number())
+			options.DefaultTimeToLive = &n
+		case "bloom_filter_fp_chance":
+			n := number()
+			options.BloomFilterFpChance = &n
+		case "comment":
+			options.Comment = unquote(value)
+		default:
+			skipped = append(skipped, key)
+		}
+	}
+
+	if len(skipped) != 0 {
+		log.Printf("Table %v options %v were not imported, the config has no field for them", name, strings.Join(skipped, ", "))
+	}
+	return options
+}
+
+// parseOptionMap reads a {'key': 'value', ...} option of a table. Numbers are kept as
+// numbers and the classes Cassandra ships are shortened to their simple names.
+func parseOptionMap(s *cqlStatement, name string, tokens []cqlToken) map[string]interface{} {
+	if len(tokens) < 2 || tokens[0].Text != "{" || tokens[len(tokens)-1].Text != "}" {
+		log.Fatalf("Could not read the options of table %v in %v", name, s.File)
+	}
+
+	res := make(map[string]interface{})
+	for _, entry := range splitTopLevel(tokens[1 : len(tokens)-1]) {
+		if len(entry) < 3 || entry[1].Text != ":" {
+			log.Fatalf("Could not read the options of table %v in %v", name, s.File)
+		}
+		key, value := unquote(entry[0].Text), unquote(joinTokens(entry[2:]))
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			res[key] = n
+		} else if b, err := strconv.ParseBool(value); err == nil && strings.ToLower(value) == value {
+			res[key] = b
+		} else if key == "class" && strings.HasPrefix(value, "org.apache.cassandra.") {
+			res[key] = value[strings.LastIndex(value, ".")+1:]
+		} else {
+			res[key] = value
+		}
+	}
+	return res
+}
+
+// cqlIndex is a secondary or storage attached index read from a CREATE INDEX.
+type cqlIndex struct {
+	Keyspace, Table, Column string
+	Index, Name             string
+}
+
+// parseCreateIndex reads the table, column and kind of a CREATE [CUSTOM] INDEX. Only
+// the indexes the config can declare are accepted.
+func parseCreateIndex(s *cqlStatement) *cqlIndex {
+	i, index := 2, &cqlIndex{Index: "secondary"}
+	if s.upper(1) == "CUSTOM" {
+		i++
+	}
+	if i = s.skipIfNotExists(i); s.upper(i) != "ON" && i < len(s.Tokens) {
+		index.Name = s.Tokens[i].Text
+		i++
+	}
+	if s.upper(i) != "ON" {
+		log.Fatalf("Could not read the table of index %v in %v", index.Name, s.File)
+	}
+
+	index.Keyspace, index.Table, i = s.qualifiedName(i + 1)
+	end := closing(s.Tokens, i)
+	if s.upper(i) != "(" || end == -1 {
+		log.Fatalf("Could not read the column of index %v in %v", index.Name, s.File)
+	}
+	switch target := s.Tokens[i+1 : end]; {
+	case len(target) == 1:
+		index.Column = target[0].Text
+	case len(target) == 4 && target[1].Text == "(" && (strings.EqualFold(target[0].Text, "VALUES") || strings.EqualFold(target[0].Text, "FULL")):
+		index.Column = target[2].Text
+	default:
+		log.Fatalf("Index %v in %v indexes %v, only columns, the values of collections and frozen collections can be indexed", index.Name, s.File, joinTokens(target))
+	}
+
+	if s.upper(1) == "CUSTOM" {
+		if s.upper(end+1) != "USING" || !strings.HasSuffix(unquote(s.text(end+2)), "StorageAttachedIndex") {
+			log.Fatalf("Index %v in %v is a custom index %v, only secondary and StorageAttachedIndex indexes can be declared", index.Name, s.File, s.text(end+1))
+		}
+		index.Index = "sai"
+	}
+	return index
+}
+
+// parseCreateType builds a user defined type from a CREATE TYPE statement.
+func parseCreateType(s *cqlStatement) (string, *typeDef) {
+	keyspace, name, i := s.qualifiedName(s.skipIfNotExists(2))
+	end := closing(s.Tokens, i)
+	if s.upper(i) != "(" || end == -1 {
+		log.Fatalf("Could not read the fields of type %v in %v", name, s.File)
+	}
+
+	udt := &typeDef{Name: name, GoName: goName(name), Fields: make([]*columnDef, 0)}
+	for _, def := range splitTopLevel(s.Tokens[i+1 : end]) {
+		if len(def) < 2 {
+			log.Fatalf("Could not read a field of type %v in %v", name, s.File)
+		}
+		udt.Fields = append(udt.Fields, &columnDef{Name: def[0].Text, Field: goName(def[0].Text), CqlType: typeText(def[1:])})
+	}
+	return keyspace, udt
+}
+
+// parseKeyspaceOptions reads the replication and durable_writes of a CREATE KEYSPACE.
+func parseKeyspaceOptions(s *cqlStatement) *keyspaceDef {
+	options := &keyspaceDef{Replication: &replicationDef{}}
+	for i := range s.Tokens {
+		switch {
+		case s.upper(i) == "REPLICATION" && s.upper(i+1) == "=" && s.upper(i+2) == "{":
+			for j := i + 3; j+2 < len(s.Tokens) && s.Tokens[j].Text != "}"; j += 4 {
+				key, value := strings.Trim(s.Tokens[j].Text, "'"), strings.Trim(s.Tokens[j+2].Text, "'")
+				factor, _ := strconv.Atoi(value)
+				switch key {
+				case "class":
+					options.Replication.Class = value[strings.LastIndex(value, ".")+1:]
+				case "replication_factor":
+					options.Replication.ReplicationFactor = factor
+				default:
+					if options.Replication.DataCenters == nil {
+						options.Replication.DataCenters = make(map[string]int)
+					}
+					options.Replication.DataCenters[key] = factor
+				}
+				if j+3 >= len(s.Tokens) || s.Tokens[j+3].Text == "}" {
+					break
+				}
+			}
+		case s.upper(i) == "DURABLE_WRITES" && s.upper(i+1) == "=":
+			durable := s.upper(i+2) == "TRUE"
+			options.DurableWrites = &durable
+		}
+	}
+
+	if options.Replication.Class == "" {
+		return nil
+	}
+	return options
+}
+
+// queryTable returns the keyspace and table a query reads or writes along with the
+// indexes of the first token of its name and of the token following it.
+func (s *cqlStatement) queryTable() (string, string, int, int) {
+	for i := range s.Tokens {
+		switch s.upper(i) {
+		case "FROM", "UPDATE", "INTO":
+			keyspace, table, next := s.qualifiedName(i + 1)
+			return keyspace, table, i + 1, next
+		}
+	}
+	log.Fatalf("Could not find the table of query %v in %v", s.Name, s.File)
+	return "", "", 0, 0
+}
+
+// collectionTypes returns the key and element types of a map, or the element
+// type of a list or set.
+func collectionTypes(cqlType string) (string, string) {
+	inner := cqlType[strings.IndexByte(cqlType, '<')+1 : strings.LastIndexByte(cqlType, '>')]
+	if parts := strings.SplitN(inner, ",", 2); strings.HasPrefix(cqlType, "map<") && len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "", inner
+}
+
+// lowerName turns a column name into an unexported Go parameter name.
+func lowerName(name string) string {
+	field := goName(name)
+	lower := strings.ToLower(field)
+	for _, initialism := range INITIALISMS {
+		if field == initialism {
+			return lower
+		}
+	}
+
+	r, n := utf8.DecodeRuneInString(field)
+	res := string(unicode.ToLower(r)) + field[n:]
+	if token.IsKeyword(res) {
+		res += "Value"
+	}
+	return res
+}
+
+// paramName turns a column name into the name of a parameter of a generated
+// method, clear of the session, err and close locals the method declares.
+func paramName(name string) string {
+	switch res := lowerName(name); res {
+	case "session", "err", "close":
+		return res + "Value"
+	default:
+		return res
+	}
+}
+
+// inferParams derives the name and cql type of every bind marker of a query
+// from the columns it is compared with, assigned to or inserted into.
+func (s *cqlStatement) inferParams(table *tableDef) []*queryParamDef {
+	column := func(name string) *columnDef {
+		for _, c := range table.Columns {
+			if strings.EqualFold(c.Name, name) {
+				return c
+			}
+		}
+		return nil
+	}
+
+	var inserted []string
+	for i := range s.Tokens {
+		if s.upper(i) == "INTO" {
+			_, _, next := s.qualifiedName(i + 1)
+			if s.upper(next) == "(" {
+				for _, c := range splitTopLevel(s.Tokens[next+1 : closing(s.Tokens, next)]) {
+					inserted = append(inserted, joinTokens(c))
+				}
+			}
+		}
+	}
+
+	params := make([]*queryParamDef, 0)
+	used := make(map[string]int)
+	values := 0
+	before := func(i int) string {
+		if i < 0 {
+			log.Fatalf("Could not match parameter %v of query %v in %v to a column", len(params)+1, s.Name, s.File)
+		}
+		return s.Tokens[i].Text
+	}
+	for i, t := range s.Tokens {
+		if t.Text != "?" {
+			continue
+		}
+
+		open := i - 1
+		for open >= 0 && (s.Tokens[open].Text == "?" || s.Tokens[open].Text == ",") {
+			open--
+		}
+
+		var name, cqlType string
+		switch prev := s.upper(i - 1); {
+		case prev == "LIMIT":
+			name, cqlType = "limit", "int"
+		case prev == "TTL":
+			name, cqlType = "ttl", "int"
+		case prev == "TIMESTAMP":
+			name, cqlType = "timestamp", "bigint"
+		case (prev == "," || prev == "(") && s.upper(open) == "(" && s.upper(open-1) == "VALUES":
+			if values >= len(inserted) {
+				log.Fatalf("Could not match parameter %v of query %v in %v to a column", len(params)+1, s.Name, s.File)
+			}
+			name = inserted[values]
+			values++
+		case (prev == "," || prev == "(") && s.upper(open) == "(" && s.upper(open-1) == "IN":
+			name = before(open - 2)
+		case prev == "+" || prev == "-":
+			name = before(i - 2)
+		case prev == "IN":
+			name = before(i - 2)
+			if c := column(name); c != nil {
+				cqlType = fmt.Sprintf("list<%v>", c.CqlType)
+			}
+		case prev == "CONTAINS":
+			name = before(i - 2)
+			if c := column(name); c != nil {
+				_, cqlType = collectionTypes(c.CqlType)
+			}
+		case prev == "KEY" && s.upper(i-2) == "CONTAINS":
+			name = before(i - 3)
+			if c := column(name); c != nil {
+				cqlType, _ = collectionTypes(c.CqlType)
+			}
+		case s.upper(i-2) == ")":
+			name, cqlType = "token", "bigint"
+		default:
+			name = before(i - 2)
+		}
+
+		if cqlType == "" {
+			c := column(name)
+			if c == nil {
+				log.Fatalf("Could not infer the type of parameter %v of query %v in %v", len(params)+1, s.Name, s.File)
+			}
+			cqlType = c.CqlType
+		}
+
+		param := paramName(name)
+		if used[param]++; used[param] > 1 {
+			param = fmt.Sprintf("%v%v", param, used[param])
+		}
+		params = append(params, &queryParamDef{Name: param, CqlType: cqlType})
+	}
+	return params
+}
+
+// parseQuery turns an annotated statement into a query on the table it targets.
+// Selects always return the whole model so only what follows the table is kept.
+func (s *cqlStatement) parseQuery(table *tableDef, first, next int) *queryDef {
+	query := &queryDef{Name: s.Name, Cardinality: s.Cardinality, Params: s.inferParams(table)}
+	if s.Cardinality == "exec" {
+		query.CQL = s.Text[:s.Tokens[first].Start] + "{table}" + s.Text[s.Tokens[next-1].End:]
+	} else if s.upper(0) != "SELECT" {
+		log.Fatalf("Query %v in %v is :%v but is not a SELECT", s.Name, s.File, s.Cardinality)
+	} else if s.upper(1) != "*" || s.upper(2) != "FROM" {
+		log.Fatalf("Query %v in %v selects %v, :%v queries return the whole model so they must SELECT *", s.Name, s.File, joinTokens(s.Tokens[1:first-1]), s.Cardinality)
+	} else {
+		query.Where = s.text(next)
+	}
+	return query
+}
+
+// loadCQL reads every .cql file in dir and merges the tables and queries it
+// declares into tables. Tables already in the config keep their names and
+// column options, but take their columns from the cql.
+func loadCQL(location string, persist *persistDef) {
+	files := []string{location}
+	if info, err := os.Stat(location); err != nil {
+		log.Fatalf("Could not read %v: %v", location, err)
+	} else if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(location, "*.cql")); err != nil {
+			log.Fatalf("Could not list cql files in %v: %v", location, err)
+		}
+		sort.Strings(files)
+	}
+
+	statements := make([]*cqlStatement, 0)
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("Could not read %v: %v", file, err)
+		}
+		statements = append(statements, parseCQL(file, string(source))...)
+	}
+
+	useKeyspace := func(keyspace string) {
+		if persist.Keyspace == "" {
+			persist.Keyspace = keyspace
+		}
+	}
+
+	qualified := func(keyspace, table string) string {
+		if keyspace == "" {
+			keyspace = persist.Keyspace
+		}
+		return strings.ToLower(keyspace + "." + table)
+	}
+
+	tables, indexes := make(map[string]*tableDef), make([]*cqlIndex, 0)
+	for _, s := range statements {
+		if s.upper(0) != "CREATE" {
+			continue
+		}
+
+		switch s.upper(1) {
+		case "KEYSPACE":
+			_, keyspace, _ := s.qualifiedName(s.skipIfNotExists(2))
+			useKeyspace(keyspace)
+			if keyspace == persist.Keyspace && persist.KeyspaceOptions == nil {
+				persist.KeyspaceOptions = parseKeyspaceOptions(s)
+			}
+		case "TYPE":
+			keyspace, udt := parseCreateType(s)
+			useKeyspace(keyspace)
+			mergeType(persist, udt)
+		case "INDEX", "CUSTOM":
+			indexes = append(indexes, parseCreateIndex(s))
+		case "TABLE":
+			parsed := parseCreateTable(s)
+			useKeyspace(parsed.Keyspace)
+			if parsed.Keyspace != "" && parsed.Keyspace != persist.Keyspace {
+				parsed.Table.Keyspace = parsed.Keyspace
+			}
+			tables[qualified(parsed.Keyspace, parsed.Table.Table)] = mergeTable(persist, parsed.Table)
+		}
+	}
+
+	lookup := func(keyspace, name string) *tableDef {
+		if table, ok := tables[qualified(keyspace, name)]; ok {
+			return table
+		}
+		for _, t := range persist.Tables {
+			if qualified(t.Keyspace, t.Table) == qualified(keyspace, name) {
+				return t
+			}
+		}
+		return nil
+	}
+
+	for _, index := range indexes {
+		t := lookup(index.Keyspace, index.Table)
+		if t == nil {
+			log.Fatalf("Index %v is on table %v which is not defined", index.Name, qualified(index.Keyspace, index.Table))
+		}
+		found := false
+		for _, c := range t.Columns {
+			if strings.EqualFold(c.Name, index.Column) {
+				c.Index, c.IndexName, found = index.Index, index.Name, true
+			}
+		}
+		if !found {
+			log.Fatalf("Index %v is on column %v which is not a column of %v", index.Name, index.Column, t.Table)
+		}
+	}
+
+	for _, s := range statements {
+		if s.Name == "" {
+			continue
+		}
+
+		keyspace, name, first, next := s.queryTable()
+		table := lookup(keyspace, name)
+		if table == nil {
+			log.Fatalf("Query %v in %v uses table %v which is not defined", s.Name, s.File, qualified(keyspace, name))
+		}
+		table.Queries = append(table.Queries, s.parseQuery(table, first, next))
+	}
+}
+
+// mergeType adds a type read from cql to the config, or replaces the fields of the
+// configured type with the same name.
+func mergeType(persist *persistDef, parsed *typeDef) {
+	for _, udt := range persist.Types {
+		if strings.EqualFold(udt.Name, parsed.Name) {
+			for _, f := range parsed.Fields {
+				for _, configured := range udt.Fields {
+					if strings.EqualFold(f.Name, configured.Name) && configured.Field != "" {
+						f.Field = configured.Field
+					}
+				}
+			}
+			udt.Fields = parsed.Fields
+			return
+		}
+	}
+	persist.Types = append(persist.Types, parsed)
+}
+
+// mergeTable adds a table read from cql to the config, or replaces the columns
+// of the configured table with the same keyspace and name.
+func mergeTable(persist *persistDef, parsed *tableDef) *tableDef {
+	keyspace := func(t *tableDef) string {
+		if t.Keyspace == "" {
+			return persist.Keyspace
+		}
+		return t.Keyspace
+	}
+
+	for _, table := range persist.Tables {
+		if !strings.EqualFold(table.Table, parsed.Table) || !strings.EqualFold(keyspace(table), keyspace(parsed)) {
+			continue
+		}
+
+		for _, c := range parsed.Columns {
+			for _, configured := range table.Columns {
+				if strings.EqualFold(c.Name, configured.Name) {
+					if configured.Field != "" {
+						c.Field = configured.Field
+					}
+					c.DeserializeFromBlob = configured.DeserializeFromBlob
+					c.Serializer = configured.Serializer
+					c.SerializationErrors = configured.SerializationErrors
+					c.Index = configured.Index
+					c.IndexName = configured.IndexName
+				}
+			}
+		}
+		table.Columns = parsed.Columns
+		if parsed.TableOptions != nil {
+			table.TableOptions = parsed.TableOptions
+		}
+		return table
+	}
+
+	persist.Tables = append(persist.Tables, parsed)
+	return parsed
+}
+
+// importConfig merges the tables and types declared by DDL, for example the output
+// of DESCRIBE KEYSPACE, into the config, creating persist-config.json when there is none.
+func importConfig(location, file string) {
+	persist := &persistDef{}
+	if file == "" {
+		file = "persist-config.json"
+	} else if _, err := os.Stat(file); err == nil {
+		if persist, err = readConfig(file, false); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	loadCQL(location, persist)
+	if persist.Package == "" {
+		persist.Package = strings.ToLower(persist.Keyspace)
+	}
+
+	if err := writeConfig(file, persist); err != nil {
+		log.Fatalf("Could not write %v: %v", file, err)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestParseCQL(t *testing.T) {
+	statements := parseCQL("test.cql", `
+/* users; and their events */
+CREATE TABLE users (id uuid PRIMARY KEY); -- trailing; comment
+
+-- name: ByName :many
+SELECT * FROM users WHERE name = 'a;''b';
+// name: Ignored :one
+CREATE FUNCTION f() RETURNS text LANGUAGE java AS $$ return "x;y"; $$`)
+
+	expected := []struct {
+		name, cardinality string
+		tokens            []string
+	}{
+		{"", "", []string{"CREATE", "TABLE", "users", "(", "id", "uuid", "PRIMARY", "KEY", ")"}},
+		{"ByName", "many", []string{"SELECT", "*", "FROM", "users", "WHERE", "name", "=", "'a;''b'"}},
+		{"", "", []string{"CREATE", "FUNCTION", "f", "(", ")", "RETURNS", "text", "LANGUAGE", "java", "AS", `$$ return "x;y"; $$`}},
+	}
+	if len(statements) != len(expected) {
+		t.Fatalf("expected %v statements, got %v", len(expected), len(statements))
+	}
+	for i, e := range expected {
+		s := statements[i]
+		tokens := make([]string, len(s.Tokens))
+		for j, token := range s.Tokens {
+			tokens[j] = token.Text
+			if s.Text[token.Start:token.End] != token.Text {
+				t.Errorf("token %v of statement %v is %q but spans %q", j, i, token.Text, s.Text[token.Start:token.End])
+			}
+		}
+		if s.Name != e.name || s.Cardinality != e.cardinality {
+			t.Errorf("statement %v is named %v :%v, expected %v :%v", i, s.Name, s.Cardinality, e.name, e.cardinality)
+		}
+		if !reflect.DeepEqual(tokens, e.tokens) {
+			t.Errorf("statement %v has tokens %q, expected %q", i, tokens, e.tokens)
+		}
+	}
+}
+
+func TestParseCreateTable(t *testing.T) {
+	for _, test := range []struct {
+		cql      string
+		keyspace string
+		table    string
+		columns  []columnDef
+	}{
+		{
+			cql:   `CREATE TABLE users (user_id uuid PRIMARY KEY, email text)`,
+			table: "users",
+			columns: []columnDef{
+				{Name: "user_id", Field: "UserID", CqlType: "uuid", Key: "partition"},
+				{Name: "email", Field: "Email", CqlType: "text"},
+			},
+		},
+		{
+			cql: `CREATE TABLE IF NOT EXISTS accounts.events (
+  kind text, user_id uuid, bucket int, created timestamp, tags MAP<text, FROZEN<list<int>>>,
+  owner text STATIC,
+  PRIMARY KEY ((user_id, bucket), created, kind)
+) WITH CLUSTERING ORDER BY (created DESC, kind ASC) AND comment = 'x'`,
+			keyspace: "accounts",
+			table:    "events",
+			columns: []columnDef{
+				{Name: "user_id", Field: "UserID", CqlType: "uuid", Key: "partition"},
+				{Name: "bucket", Field: "Bucket", CqlType: "int", Key: "partition"},
+				{Name: "created", Field: "Created", CqlType: "timestamp", Key: "cluster-desc"},
+				{Name: "kind", Field: "Kind", CqlType: "text", Key: "cluster-asc"},
+				{Name: "tags", Field: "Tags", CqlType: "map<text,frozen<list<int>>>"},
+				{Name: "owner", Field: "Owner", CqlType: "text", Key: "static"},
+			},
+		},
+		{
+			cql:   `CREATE TABLE things ("id" uuid, "type" text, "Order" int, PRIMARY KEY ("id", "type"))`,
+			table: "things",
+			columns: []columnDef{
+				{Name: `"id"`, Field: "ID", CqlType: "uuid", Key: "partition"},
+				{Name: `"type"`, Field: "Type", CqlType: "text", Key: "cluster"},
+				{Name: `"Order"`, Field: "Order", CqlType: "int"},
+			},
+		},
+	} {
+		parsed := parseCreateTable(parseCQL("test.cql", test.cql)[0])
+		if parsed.Keyspace != test.keyspace || parsed.Table.Table != test.table {
+			t.Errorf("%v was parsed as %v.%v", test.cql, parsed.Keyspace, parsed.Table.Table)
+		}
+
+		columns := make([]columnDef, len(parsed.Table.Columns))
+		for i, c := range parsed.Table.Columns {
+			columns[i] = *c
+		}
+		if !reflect.DeepEqual(columns, test.columns) {
+			t.Errorf("%v has columns\n  %+v\nexpected\n  %+v", test.cql, columns, test.columns)
+		}
+	}
+}
+
+func TestInferParams(t *testing.T) {
+	table := parseCreateTable(parseCQL("test.cql", `CREATE TABLE users (
+  user_id uuid, bucket int, type text, session text, tags set<text>, attrs map<int, text>, visits counter,
+  PRIMARY KEY ((user_id, bucket))
+)`)[0]).Table
+
+	for _, test := range []struct {
+		cql    string
+		params []queryParamDef
+	}{
+		{`SELECT * FROM users WHERE user_id = ? AND bucket = ?`,
+			[]queryParamDef{{"userID", "uuid"}, {"bucket", "int"}}},
+		{`SELECT * FROM users WHERE user_id IN ? AND bucket = ? LIMIT ?`,
+			[]queryParamDef{{"userID", "list<uuid>"}, {"bucket", "int"}, {"limit", "int"}}},
+		{`SELECT * FROM users WHERE user_id IN (?, ?) AND bucket = ?`,
+			[]queryParamDef{{"userID", "uuid"}, {"userID2", "uuid"}, {"bucket", "int"}}},
+		{`SELECT * FROM users WHERE tags CONTAINS ? AND attrs CONTAINS KEY ? AND attrs CONTAINS ? ALLOW FILTERING`,
+			[]queryParamDef{{"tags", "text"}, {"attrs", "int"}, {"attrs2", "text"}}},
+		{`SELECT * FROM users WHERE token(user_id, bucket) > ? AND token(user_id, bucket) <= ?`,
+			[]queryParamDef{{"token", "bigint"}, {"token2", "bigint"}}},
+		{`UPDATE users USING TTL ? AND TIMESTAMP ? SET type = ?, session = ? WHERE user_id = ? AND bucket = ?`,
+			[]queryParamDef{{"ttl", "int"}, {"timestamp", "bigint"}, {"typeValue", "text"}, {"sessionValue", "text"}, {"userID", "uuid"}, {"bucket", "int"}}},
+		{`UPDATE users SET visits = visits + ? WHERE user_id = ? AND bucket = ?`,
+			[]queryParamDef{{"visits", "counter"}, {"userID", "uuid"}, {"bucket", "int"}}},
+		{`INSERT INTO users (user_id, bucket, type) VALUES (?, ?, ?) USING TTL ?`,
+			[]queryParamDef{{"userID", "uuid"}, {"bucket", "int"}, {"typeValue", "text"}, {"ttl", "int"}}},
+	} {
+		params := make([]queryParamDef, 0)
+		for _, p := range parseCQL("test.cql", test.cql)[0].inferParams(table) {
+			params = append(params, *p)
+		}
+		if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%v has params %v, expected %v", test.cql, params, test.params)
+		}
+	}
+}
+
+func TestParamName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"user_id":  "userID",
+		"UserID":   "userID",
+		"ID":       "id",
+		"type":     "typeValue",
+		`"type"`:   "typeValue",
+		`"Order"`:  "order",
+		"session":  "sessionValue",
+		"err":      "errValue",
+		"close":    "closeValue",
+		"close_at": "closeAt",
+	} {
+		if actual := paramName(name); actual != expected {
+			t.Errorf("paramName(%q) is %q, expected %q", name, actual, expected)
+		}
+	}
+}
+
+func TestMergeTable(t *testing.T) {
+	configured := &tableDef{Table: "users", Model: "Account", Columns: []*columnDef{
+		{Name: "email", Field: "Mail", Index: "sai", IndexName: "users_by_mail", DeserializeFromBlob: "string"},
+		{Name: "name", Index: "secondary"},
+	}}
+	persist := &persistDef{Tables: []*tableDef{configured}}
+	parsed := parseCreateTable(parseCQL("test.cql", `CREATE TABLE users (user_id uuid PRIMARY KEY, email text, name text)`)[0]).Table
+
+	if merged := mergeTable(persist, parsed); merged != configured {
+		t.Fatalf("users was not merged into the configured table")
+	} else if merged.Model != "Account" || len(persist.Tables) != 1 {
+		t.Errorf("merging replaced the configured table %+v", merged)
+	}
+
+	expected := []columnDef{
+		{Name: "user_id", Field: "UserID", CqlType: "uuid", Key: "partition"},
+		{Name: "email", Field: "Mail", CqlType: "text", Index: "sai", IndexName: "users_by_mail", DeserializeFromBlob: "string"},
+		{Name: "name", Field: "Name", CqlType: "text", Index: "secondary"},
+	}
+	columns := make([]columnDef, len(configured.Columns))
+	for i, c := range configured.Columns {
+		columns[i] = *c
+	}
+	if !reflect.DeepEqual(columns, expected) {
+		t.Errorf("merged columns are\n  %+v\nexpected\n  %+v", columns, expected)
+	}
+
+	other := &tableDef{Table: "events"}
+	if merged := mergeTable(persist, other); merged != other || len(persist.Tables) != 2 {
+		t.Errorf("events was not added to the config")
+	}
+}
+
+func TestLoadCQLKeyspaces(t *testing.T) {
+	dir := t.TempDir()
+	cql := `
+CREATE TABLE accounts.users (user_id uuid PRIMARY KEY, email text);
+CREATE TABLE archive.users (user_id uuid PRIMARY KEY, archived timestamp);
+
+-- name: ByEmail :many
+SELECT * FROM users WHERE email = ? ALLOW FILTERING;
+
+-- name: Archived :many
+SELECT * FROM archive.users WHERE archived > ? ALLOW FILTERING;`
+	if err := os.WriteFile(path.Join(dir, "schema.cql"), []byte(cql), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	persist := &persistDef{}
+	loadCQL(dir, persist)
+	if len(persist.Tables) != 2 {
+		t.Fatalf("expected a users table per keyspace, got %v", len(persist.Tables))
+	}
+
+	for i, expected := range []struct{ keyspace, query string }{{"", "ByEmail"}, {"archive", "Archived"}} {
+		table := persist.Tables[i]
+		if table.Keyspace != expected.keyspace || len(table.Queries) != 1 || table.Queries[0].Name != expected.query {
+			t.Errorf("table %v of keyspace %q has queries %+v, expected %v in %q", i, table.Keyspace, table.Queries, expected.query, expected.keyspace)
+		}
+	}
+}
+
+func TestImportDescribe(t *testing.T) {
+	dir := t.TempDir()
+	cql := `
+CREATE TYPE accounts."HomeAddress" (street text);
+
+CREATE TABLE accounts.users (
+    user_id uuid PRIMARY KEY,
+    email text,
+    tags set<text>,
+    home frozen<"HomeAddress">
+) WITH additional_write_policy = '99p'
+    AND bloom_filter_fp_chance = 0.01
+    AND caching = {'keys': 'ALL', 'rows_per_partition': 'NONE'}
+    AND comment = 'it''s users'
+    AND compaction = {'class': 'org.apache.cassandra.db.compaction.SizeTieredCompactionStrategy', 'max_threshold': '32', 'min_threshold': '4'}
+    AND compression = {'chunk_length_in_kb': '16', 'class': 'org.apache.cassandra.io.compress.LZ4Compressor', 'enabled': 'true'}
+    AND default_time_to_live = 0
+    AND gc_grace_seconds = 864000;
+
+CREATE INDEX users_by_email ON accounts.users (email);
+CREATE CUSTOM INDEX users_by_tag ON accounts.users (values(tags)) USING 'StorageAttachedIndex';`
+	if err := os.WriteFile(path.Join(dir, "schema.cql"), []byte(cql), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	persist := &persistDef{}
+	loadCQL(dir, persist)
+	if len(persist.Types) != 1 || persist.Types[0].Name != `"HomeAddress"` {
+		t.Errorf("types are %+v, expected the quoted HomeAddress", persist.Types)
+	}
+
+	users := persist.Tables[0]
+	indexes := make(map[string][2]string)
+	for _, c := range users.Columns {
+		if c.Index != "" {
+			indexes[c.Name] = [2]string{c.Index, c.IndexName}
+		}
+		if c.Name == "home" && c.CqlType != `frozen<"HomeAddress">` {
+			t.Errorf("home has type %v", c.CqlType)
+		}
+	}
+	if expected := map[string][2]string{"email": {"secondary", "users_by_email"}, "tags": {"sai", "users_by_tag"}}; !reflect.DeepEqual(indexes, expected) {
+		t.Errorf("indexes are %v, expected %v", indexes, expected)
+	}
+
+	options := users.TableOptions
+	if options == nil {
+		t.Fatalf("users has no options")
+	}
+	if clauses, expected := options.clauses(), []string{
+		"compaction = {'class': 'SizeTieredCompactionStrategy', 'max_threshold': 32, 'min_threshold': 4}",
+		"compression = {'class': 'LZ4Compressor', 'chunk_length_in_kb': 16, 'enabled': true}",
+		"gc_grace_seconds = 864000",
+		"caching = {'keys': 'ALL', 'rows_per_partition': 'NONE'}",
+		"bloom_filter_fp_chance = 0.01",
+		"default_time_to_live = 0",
+		"comment = 'it''s users'",
+	}; !reflect.DeepEqual(clauses, expected) {
+		t.Errorf("users has options\n  %v\nexpected\n  %v", clauses, expected)
+	}
+}
@@ -9,18 +9,26 @@ import (
 	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 
 	"go/format"
+	"go/parser"
+	"go/token"
 )
 
 // Usage is a replacement usage function for the flags package.
 func Usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "\tgocql-gen\n")
+	fmt.Fprintf(os.Stderr, "\tgocql-gen [flags]\n")
+	fmt.Fprintf(os.Stderr, "\tgocql-gen schema > persist-config.schema.json\n")
+	fmt.Fprintf(os.Stderr, "\tgocql-gen [-drop] migrate [name]\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr, "For more information, see:\n")
 	fmt.Fprintf(os.Stderr, "\thttps://github.com/timthesinner/gocql-gen\n")
 }
@@ -31,13 +39,68 @@ type tableDef struct {
 	DAO           string       `json:"dao"`
 	GeneratedName string       `json:"generatedName"`
 	Columns       []*columnDef `json:"columns"`
+	Queries       []*queryDef  `json:"queries,omitempty"`
+	Views         []*viewDef   `json:"views,omitempty"`
+
+	Keyspace          string    `json:"keyspace,omitempty"`
+	Package           string    `json:"package,omitempty"`
+	AdditionalImports []string  `json:"imports,omitempty"`
+	ModelImport       string    `json:"modelPackage,omitempty"`
+	ModelGeneration   *modelDef `json:"ModelGeneration,omitempty"`
+	Location          string    `json:"location,omitempty"`
+	RuntimeKeyspace   bool      `json:"runtimeKeyspace,omitempty"`
+
+	TableOptions    *tableOptionsDef `json:"tableOptions,omitempty"`
+	KeyspaceOptions *keyspaceDef     `json:"keyspaceOptions,omitempty"`
+
+	boilerPlate string
+}
+
+type queryDef struct {
+	Name        string           `json:"name"`
+	Where       string           `json:"where,omitempty"`
+	CQL         string           `json:"cql,omitempty"`
+	Params      []*queryParamDef `json:"params"`
+	Cardinality string           `json:"cardinality"`
+}
+
+type viewDef struct {
+	Name          string        `json:"name"`
+	DAO           string        `json:"dao"`
+	GeneratedName string        `json:"generatedName,omitempty"`
+	Keys          []*viewKeyDef `json:"keys"`
+}
+
+type viewKeyDef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+type queryParamDef struct {
+	Name    string `json:"name"`
+	CqlType string `json:"type"`
 }
 
 type columnDef struct {
 	Name                string `json:"name"`
+	Field               string `json:"field,omitempty"`
 	CqlType             string `json:"type"`
-	Key                 string `json:"key"`
-	DeserializeFromBlob string `json:"deserializeTo"`
+	Key                 string `json:"key,omitempty"`
+	DeserializeFromBlob string `json:"deserializeTo,omitempty"`
+	Serializer          string `json:"serializer,omitempty"`
+	SerializationErrors string `json:"serializationErrors,omitempty"`
+	Index               string `json:"index,omitempty"`
+	IndexName           string `json:"indexName,omitempty"`
+
+	goType string
+}
+
+type typeDef struct {
+	Name   string       `json:"name"`
+	GoName string       `json:"goName,omitempty"`
+	Fields []*columnDef `json:"fields"`
+
+	generation *modelDef
 }
 
 type modelDef struct {
@@ -46,58 +109,157 @@ type modelDef struct {
 }
 
 type persistDef struct {
-	Keyspace          string      `json:"keyspace"`
-	Package           string      `json:"package"`
-	BoilerPlate       string      `json:"boilerplate"`
-	AdditionalImports []string    `json:"imports"`
-	ModelImport       string      `json:"modelPackage"`
-	ModelGeneration   *modelDef   `json:"ModelGeneration"`
-	Tables            []*tableDef `json:"tables"`
+	Keyspace          string       `json:"keyspace"`
+	Package           string       `json:"package"`
+	KeyspaceOptions   *keyspaceDef `json:"keyspaceOptions,omitempty"`
+	BoilerPlate       string       `json:"boilerplate,omitempty"`
+	AdditionalImports []string     `json:"imports,omitempty"`
+	ModelImport       string       `json:"modelPackage,omitempty"`
+	ModelGeneration   *modelDef    `json:"ModelGeneration,omitempty"`
+	Types             []*typeDef   `json:"types,omitempty"`
+	Tables            []*tableDef  `json:"tables"`
+	CQL               string       `json:"cql,omitempty"`
+	Models            string       `json:"models,omitempty"`
+	Location          string       `json:"location,omitempty"`
+	Include           []string     `json:"include,omitempty"`
+	RuntimeKeyspace   bool         `json:"runtimeKeyspace,omitempty"`
 }
 
 var COLLECTION_REGEX = regexp.MustCompile(`list<(.*)>|set<(.*)>`)
+var MAP_REGEX = regexp.MustCompile(`^map<\s*(\w+)\s*,\s*(.+?)\s*>$`)
+var FROZEN_REGEX = regexp.MustCompile(`^frozen<\s*(.+?)\s*>$`)
+var IDENTIFIER_REGEX = regexp.MustCompile(`[\w.]+`)
 
 func (c *columnDef) String() string {
 	return fmt.Sprintf("{Name:%v,Type:%v,Key:%v}", c.Name, c.CqlType, c.Key)
 }
 
-func open(file string) (*os.File, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return os.Open(path.Join("config", file))
+var INITIALISMS = map[string]string{
+	"api": "API", "http": "HTTP", "id": "ID", "ip": "IP", "json": "JSON", "ttl": "TTL", "url": "URL", "uuid": "UUID",
+}
+
+// goName turns a cql column name such as user_id or "type" into an exported
+// Go identifier, leaving names that already are one untouched.
+func goName(name string) string {
+	name = strings.Trim(name, `"`)
+	if r, _ := utf8.DecodeRuneInString(name); unicode.IsUpper(r) && !strings.Contains(name, "_") {
+		return name
+	}
+
+	var res strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part == "" {
+			continue
+		} else if initialism, ok := INITIALISMS[strings.ToLower(part)]; ok {
+			res.WriteString(initialism)
+		} else {
+			r, n := utf8.DecodeRuneInString(part)
+			res.WriteRune(unicode.ToUpper(r))
+			res.WriteString(part[n:])
+		}
 	}
-	return f, nil
+	return res.String()
 }
 
+var cqlDir = flag.String("cql", "", "directory of .cql files declaring tables and annotated queries, overrides the cql config value")
+var modelsDir = flag.String("models", "", "directory of Go structs with cql tags to generate DAOs for, overrides the models config value")
+var importCQL = flag.String("import", "", "cql DDL file or directory to import into the config instead of generating")
+var dropRemoved = flag.Bool("drop", false, "let migrate drop the tables and types removed from the config, with their rows")
+var emitCQL = flag.Bool("emit-cql", false, "also write the DDL the DAOs run in Init to schema.cql, in dependency order")
+var configFile = flag.String("config", "", "config file, a .json, .yaml, .yml or .toml persist-config in . or config/ by default")
+
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("gocql-gen: ")
 	flag.Usage = Usage
 	flag.Parse()
 
+	if flag.Arg(0) == "schema" {
+		if err := writeSchema(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	file, err := *configFile, error(nil)
+	if file == "" {
+		file, err = findConfig()
+	}
+
+	if *importCQL != "" {
+		importConfig(*importCQL, file)
+		return
+	}
+
 	var persist *persistDef
-	if p, err := open("persist-config.json"); err != nil {
-		log.Fatal(err)
-	} else if err := json.NewDecoder(p).Decode(&persist); err != nil {
+	if err != nil {
+		if *modelsDir == "" {
+			log.Fatal(err)
+		}
+		persist = &persistDef{}
+	} else if persist, err = readConfig(file, true); err != nil {
 		log.Fatal(err)
-	} else if len(persist.Tables) == 0 {
+	} else {
+		resolvePaths(persist, path.Dir(file))
+	}
+
+	if *cqlDir != "" {
+		persist.CQL = *cqlDir
+	}
+	if *modelsDir != "" {
+		persist.Models = *modelsDir
+	}
+
+	if persist.CQL != "" {
+		loadCQL(persist.CQL, persist)
+	}
+	if persist.Models != "" {
+		loadModels(persist.Models, persist.Location, persist)
+	}
+	for _, table_def := range persist.Tables {
+		table_def.inherit(persist)
+	}
+	seen := map[string]bool{}
+	if abs, err := filepath.Abs(file); err == nil {
+		seen[abs] = true
+	}
+	includeConfigs(persist, path.Dir(file), seen)
+
+	for _, udt := range persist.Types {
+		if udt.GoName == "" {
+			udt.GoName = goName(udt.Name)
+		}
+	}
+
+	if len(persist.Tables) == 0 {
 		log.Fatalf("At least one table must be defined")
 	} else {
-		for _, table_def := range persist.Tables {
+		models := make([]*_DAOModel, len(persist.Tables))
+		for i, table_def := range persist.Tables {
 			if len(table_def.Columns) == 0 {
 				log.Fatalf("Table %v had no columns defined", table_def.Table)
+			} else if table_def.TableOptions != nil {
+				table_def.TableOptions.validate(table_def.Table)
+			}
+			if table_def.KeyspaceOptions != nil {
+				table_def.KeyspaceOptions.validate(table_def.Keyspace)
 			}
 
-			model := _DAOModel{
-				Keyspace:          persist.Keyspace,
-				Package:           persist.Package,
-				BoilerPlate:       persist.BoilerPlate,
-				AdditionalImports: persist.AdditionalImports,
-				ModelImport:       persist.ModelImport,
+			model := &_DAOModel{
+				Keyspace:          table_def.Keyspace,
+				Package:           table_def.Package,
+				BoilerPlate:       table_def.boilerPlate,
+				AdditionalImports: table_def.AdditionalImports,
+				ModelImport:       table_def.ModelImport,
 				Model:             table_def.Model,
 				Table:             table_def.Table,
 				DAO:               table_def.DAO,
 				IncludeTime:       false,
+				RuntimeKeyspace:   table_def.RuntimeKeyspace,
+				iterators:         supportsIterators(table_def.Location),
+				tableOptions:      table_def.TableOptions,
+				keyspaceOptions:   table_def.KeyspaceOptions,
+				types:             persist.Types,
 			}
 
 			for _, col := range table_def.Columns {
@@ -109,6 +271,8 @@ func main() {
 				case "cluster", "cluster-asc", "cluster-desc":
 					model.clusteringKeys = append(model.clusteringKeys, col.Name)
 					model.keys = append(model.keys, col.Name)
+				case "static":
+					model.statics = append(model.statics, col.Name)
 				}
 
 				switch col.Key {
@@ -118,80 +282,97 @@ func main() {
 					model.clusteringOrder = append(model.clusteringOrder, col.Name+" DESC")
 				}
 
-				column := &param{Name: col.Name, CqlType: col.CqlType}
-				switch col.CqlType {
-				case "text":
-					column.GoType = "string"
-				case "uuid", "timeuuid":
-					column.GoType = "*gocql.UUID"
-					model.IncludeGoCql = true
-				case "int":
-					column.GoType = "int"
-				case "double":
-					column.GoType = "float64"
-				case "blob":
-					column.GoType = "[]byte"
-				case "timestamp":
-					column.GoType = "*time.Time"
-					model.IncludeTime = true
-				case "list<blob>":
-					column.GoType = "[][]byte"
-					column.SerializedType = col.DeserializeFromBlob
-					if column.SerializedType != "" {
-						model.IncludeJson = true
-					}
-				case "map<text,blob>":
-					column.GoType = "map[string][]byte"
-					column.SerializedType = col.DeserializeFromBlob
-					if column.SerializedType != "" {
-						model.IncludeJson = true
+				column := &param{Name: col.Name, Field: col.Field, CqlType: col.CqlType}
+				if column.Field == "" {
+					column.Field = col.Name
+				}
+				switch col.SerializationErrors {
+				case "", "log":
+				case "strict", "lenient":
+					if col.DeserializeFromBlob == "" {
+						log.Fatalf("Column %v declared serializationErrors %v without deserializeTo", col.Name, col.SerializationErrors)
 					}
+					column.SerializationErrors = col.SerializationErrors
+					model.IncludeSerializationErrors = true
 				default:
-					if match := COLLECTION_REGEX.FindStringSubmatch(col.CqlType); len(match) == 3 {
-						t := match[1]
-						if t == "" {
-							t = match[2]
-						}
-						switch t {
-						case "text":
-							column.GoType = "[]string"
-						case "uuid", "timeuuid":
-							column.GoType = "[]*gocql.UUID"
-							model.IncludeGoCql = true
-						case "timestamp":
-							column.GoType = "[]time.Time"
-							model.IncludeTime = true
-						case "int":
-							column.GoType = "[]int"
-						case "double":
-							column.GoType = "[]float64"
-						case "blob":
-							column.GoType = "[][]byte"
-						default:
-							log.Fatalf("Column %v with type %v was not mapped to a gocql value", col.Name, col.CqlType)
-						}
-					} else {
-						log.Fatalf("Column %v with type %v was not mapped to a gocql value", col.Name, col.CqlType)
+					log.Fatalf("Column %v had unknown serializationErrors %v, expected log, strict or lenient", col.Name, col.SerializationErrors)
+				}
+
+				column.GoType, column.KeyGoType, column.Container = model.goType(col.Name, col.CqlType)
+				if col.goType != "" {
+					column.GoType = col.goType
+					model.IncludeTime = model.IncludeTime || strings.HasPrefix(col.goType, "time.")
+				}
+
+				if col.DeserializeFromBlob != "" {
+					if column.Container == "" {
+						log.Fatalf("Column %v with type %v can not declare deserializeTo, only blob valued columns can", col.Name, col.CqlType)
 					}
+					column.SerializedType = col.DeserializeFromBlob
+				}
+
+				if col.Serializer != "" && column.SerializedType == "" {
+					log.Fatalf("Column %v declared serializer %v without deserializeTo", col.Name, col.Serializer)
+				} else if column.SerializedType != "" {
+					model.useSerializer(column, col.Serializer)
+				}
+
+				switch col.Index {
+				case "":
+				case "secondary", "sai":
+					model.useIndex(column, col.Index, col.IndexName)
+				default:
+					log.Fatalf("Column %v had unknown index %v, expected secondary or sai", col.Name, col.Index)
 				}
 				model.Columns = append(model.Columns, column)
 			}
 
+			for _, c := range model.Indexes() {
+				if len(model.partitioningKeys) == 1 && model.partitioningKeys[0] == c.Name {
+					log.Fatalf("Column %v is the partition key of %v and can not be indexed", c.Name, table_def.Table)
+				}
+			}
+			model.useCounters()
+			if model.counter && len(table_def.Views) != 0 {
+				log.Fatalf("Table %v is a counter table, counter tables can not have views", table_def.Table)
+			} else if len(model.statics) != 0 && len(model.clusteringKeys) == 0 {
+				log.Fatalf("Table %v declares the static columns %v without clustering columns, static columns are shared by the rows of a partition", table_def.Table, strings.Join(model.statics, ", "))
+			} else if len(model.statics) != 0 && len(table_def.Views) != 0 {
+				log.Fatalf("Table %v has static columns, views can not select static columns", table_def.Table)
+			}
+
+			for _, query := range table_def.Queries {
+				model.Queries = append(model.Queries, model.namedQuery(query))
+			}
+
+			for _, view := range table_def.Views {
+				model.views = append(model.views, model.materializedView(view))
+			}
+			models[i] = model
+		}
+
+		if flag.Arg(0) == "migrate" {
+			migrate(persist, models, flag.Arg(1), *dropRemoved)
+			return
+		}
+
+		for i, table_def := range persist.Tables {
+			model := models[i]
 			var result bytes.Buffer
-			if template, err := template.New("DaoTemplate").Parse(_DAOTemplate); err != nil {
+			if daoTemplate, err := template.New("DaoTemplate").Parse(_DAOTemplate + _ReadTemplate); err != nil {
 				log.Fatalf("DAOTemplate was not legal: %v", err)
-			} else if err := template.Execute(&result, model); err != nil {
+			} else if err := daoTemplate.Execute(&result, model); err != nil {
 				log.Fatalf("Error executing template for %v: %v", table_def.Table, err)
 			} else if res, err := format.Source(result.Bytes()); err != nil {
 				log.Fatalf("Error formatting template for %v: %v\n%v", table_def.Table, err, string(result.Bytes()))
-			} else if dao, err := os.Create(strings.ToLower(fmt.Sprintf("%v-dao_gen.go", table_def.GeneratedName))); err != nil {
+			} else if dao, err := os.Create(sourceFile(table_def.Location, table_def.GeneratedName+"-dao_gen.go")); err != nil {
 				log.Fatalf("Could not create dao_gen source file: %v", err)
 			} else if i, err := dao.Write(res); err != nil {
 				log.Fatalf("Error writing template for %v: %v", table_def.Table, err)
 			} else if i != len(res) {
 				log.Fatalf("Did not write all template bytes for %v", table_def.Table)
-			} else if persist.ModelGeneration != nil {
-				model.Package = persist.ModelGeneration.Package
+			} else if table_def.ModelGeneration != nil {
+				model.Package = table_def.ModelGeneration.Package
 				var modelResult bytes.Buffer
 				if mTemplate, err := template.New("ModelTemplate").Parse(_DTOTemplate); err != nil {
 					log.Fatalf("DTOTemplate was not legal: %v", err)
@@ -199,7 +380,7 @@ func main() {
 					log.Fatalf("Error executing dto template for %v: %v", table_def.Model, err)
 				} else if res, err := format.Source(modelResult.Bytes()); err != nil {
 					log.Fatalf("Error formatting dto template for %v: %v\n%v", table_def.Table, err, string(modelResult.Bytes()))
-				} else if dto, err := os.Create(strings.ToLower(path.Join(persist.ModelGeneration.Location, fmt.Sprintf("%v-dto_gen.go", table_def.GeneratedName)))); err != nil {
+				} else if dto, err := os.Create(sourceFile(table_def.ModelGeneration.Location, table_def.GeneratedName+"-dto_gen.go")); err != nil {
 					log.Fatalf("Could not create dto_gen source file: %v", err)
 				} else if i, err := dto.Write(res); err != nil {
 					log.Fatalf("Error writing dto template for %v: %v", table_def.Table, err)
@@ -207,15 +388,114 @@ func main() {
 					log.Fatalf("Did not write all dao template bytes for %v", table_def.Table)
 				}
 			}
+
+			for j, view := range model.views {
+				name := table_def.Views[j].GeneratedName
+				if name == "" {
+					name = view.Table
+				}
+				writeTemplate("ViewTemplate", _ViewDAOTemplate+_ReadTemplate, view, sourceFile(table_def.Location, name+"-dao_gen.go"))
+			}
+		}
+
+		generated := make(map[string]*_DAOModel)
+		for _, udt := range persist.Types {
+			if udt.generation == nil {
+				udt.generation = persist.ModelGeneration
+			}
+			if udt.generation == nil {
+				continue
+			}
+
+			model, ok := generated[udt.generation.Location]
+			if !ok {
+				model = &_DAOModel{Package: udt.generation.Package, ModelImport: persist.ModelImport, types: persist.Types}
+				generated[udt.generation.Location] = model
+			}
+			model.generatedTypes = append(model.generatedTypes, udt)
+		}
+		for location, model := range generated {
+			writeTemplate("UDTTemplate", _UDTTemplate, model, sourceFile(location, "udt-dto_gen.go"))
+		}
+		checkSnapshot(persist, models)
+		writeMigrationRunner(persist, models)
+		if *emitCQL {
+			writeSchemaCQL(persist, models)
+		}
+	}
+}
+
+// writeTemplate executes and formats a template, then writes it to file.
+func writeTemplate(name, text string, model interface{}, file string) {
+	var result bytes.Buffer
+	if t, err := template.New(name).Parse(text); err != nil {
+		log.Fatalf("%v was not legal: %v", name, err)
+	} else if err := t.Execute(&result, model); err != nil {
+		log.Fatalf("Error executing %v: %v", name, err)
+	} else if res, err := format.Source(result.Bytes()); err != nil {
+		log.Fatalf("Error formatting %v: %v\n%v", name, err, string(result.Bytes()))
+	} else if err := os.WriteFile(file, res, 0644); err != nil {
+		log.Fatalf("Could not write %v: %v", file, err)
+	}
+}
+
+// GO_VERSION_REGEX matches the go directive of a go.mod.
+var GO_VERSION_REGEX = regexp.MustCompile(`(?m)^go\s+(\d+)\.(\d+)`)
+
+// supportsIterators reports whether the module enclosing dir may use the iter package,
+// which needs Go 1.23. Code outside a module is assumed to build with a current Go.
+func supportsIterators(dir string) bool {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		log.Fatalf("Could not resolve %v: %v", dir, err)
+	}
+
+	for root := abs; ; root = filepath.Dir(root) {
+		if mod, err := os.ReadFile(filepath.Join(root, "go.mod")); err == nil {
+			match := GO_VERSION_REGEX.FindSubmatch(mod)
+			if match == nil {
+				return true
+			}
+			major, _ := strconv.Atoi(string(match[1]))
+			minor, _ := strconv.Atoi(string(match[2]))
+			return major > 1 || major == 1 && minor >= 23
+		} else if filepath.Dir(root) == root {
+			return true
 		}
 	}
 }
 
+// sourceFile returns the path of a generated source file in dir. Only the file name is
+// lower cased, the directories are used as configured.
+func sourceFile(dir, name string) string {
+	return path.Join(dir, strings.ToLower(name))
+}
+
 type param struct {
 	Name           string
+	Field          string `json:"Field,omitempty"`
 	GoType         string
 	CqlType        string
 	SerializedType string `json:"SerializedType,omitempty"`
+	Container      string `json:"Container,omitempty"`
+	KeyGoType      string `json:"KeyGoType,omitempty"`
+
+	Serializer          string `json:"Serializer,omitempty"`
+	SerializationErrors string `json:"SerializationErrors,omitempty"`
+
+	Index         string `json:"Index,omitempty"`
+	IndexName     string `json:"IndexName,omitempty"`
+	IndexTarget   string `json:"IndexTarget,omitempty"`
+	IndexOperator string `json:"IndexOperator,omitempty"`
+	IndexGoType   string `json:"IndexGoType,omitempty"`
+}
+
+type namedQuery struct {
+	Name        string
+	Where       string
+	CQL         string
+	Cardinality string
+	Params      []*param
 }
 
 type _DAOModel struct {
@@ -223,20 +503,336 @@ type _DAOModel struct {
 	AdditionalImports []string
 	IncludeTime       bool
 	IncludeJson       bool
+	IncludeGob        bool
+	IncludeProtobuf   bool
+	IncludeMsgpack    bool
 	IncludeGoCql      bool
 	Model             string
 	ModelImport       string
 	DAO               string
 	BoilerPlate       string
 
-	Keyspace string
-	Table    string
-	Columns  []*param
+	IncludeSerializationErrors bool
+	IncludeCustomSerializer    bool
+
+	Keyspace        string
+	RuntimeKeyspace bool
+	Table           string
+	Columns         []*param
+	Queries         []*namedQuery
 
+	types            []*typeDef
+	generatedTypes   []*typeDef
 	partitioningKeys []string
 	clusteringKeys   []string
 	clusteringOrder  []string
+	tableOptions     *tableOptionsDef
+	keyspaceOptions  *keyspaceDef
 	keys             []string
+	views            []*_DAOModel
+	view             string
+	counter          bool
+	statics          []string
+	iterators        bool
+}
+
+// goType maps a cql type to the Go type it is scanned into. Blob valued columns also
+// report the collection holding their blobs, and maps the Go type of their keys.
+func (m *_DAOModel) goType(name, cqlType string) (goType, keyGoType, container string) {
+	if match := FROZEN_REGEX.FindStringSubmatch(cqlType); match != nil {
+		return m.goType(name, match[1])
+	}
+
+	switch cqlType {
+	case "text", "ascii", "varchar", "inet":
+		goType = "string"
+	case "uuid", "timeuuid":
+		goType = "*gocql.UUID"
+		m.IncludeGoCql = true
+	case "int":
+		goType = "int"
+	case "bigint", "counter":
+		goType = "int64"
+	case "smallint":
+		goType = "int16"
+	case "tinyint":
+		goType = "int8"
+	case "boolean":
+		goType = "bool"
+	case "float":
+		goType = "float32"
+	case "double":
+		goType = "float64"
+	case "blob":
+		goType = "[]byte"
+		container = "blob"
+	case "timestamp", "date":
+		goType = "*time.Time"
+		m.IncludeTime = true
+	default:
+		if udt := m.udt(cqlType); udt != nil {
+			goType = "*" + m.udtGoType(udt)
+		} else if match := MAP_REGEX.FindStringSubmatch(cqlType); match != nil {
+			keyGoType = m.elementType(name, cqlType, match[1])
+			if match[2] == "blob" {
+				goType = fmt.Sprintf("map[%v][]byte", keyGoType)
+				container = "map"
+			} else {
+				goType = fmt.Sprintf("map[%v]%v", keyGoType, m.elementType(name, cqlType, match[2]))
+			}
+		} else if match := COLLECTION_REGEX.FindStringSubmatch(cqlType); len(match) == 3 {
+			t := match[1]
+			if t == "" {
+				t = match[2]
+			}
+			switch t {
+			case "text":
+				goType = "[]string"
+			case "uuid", "timeuuid":
+				goType = "[]*gocql.UUID"
+				m.IncludeGoCql = true
+			case "timestamp":
+				goType = "[]time.Time"
+				m.IncludeTime = true
+			case "int":
+				goType = "[]int"
+			case "double":
+				goType = "[]float64"
+			case "blob":
+				goType = "[][]byte"
+				container = "list"
+				if match[1] == "" {
+					container = "set"
+				}
+			default:
+				goType = "[]" + m.elementType(name, cqlType, t)
+			}
+		} else {
+			log.Fatalf("Column %v with type %v was not mapped to a gocql value", name, cqlType)
+		}
+	}
+	return
+}
+
+// elementType maps the keys and values held by a collection column to Go types.
+func (m *_DAOModel) elementType(name, cqlType, elementType string) string {
+	if match := FROZEN_REGEX.FindStringSubmatch(elementType); match != nil {
+		elementType = match[1]
+	}
+
+	switch elementType {
+	case "text", "ascii", "varchar", "inet":
+		return "string"
+	case "uuid", "timeuuid":
+		m.IncludeGoCql = true
+		return "gocql.UUID"
+	case "int":
+		return "int"
+	case "bigint":
+		return "int64"
+	case "smallint":
+		return "int16"
+	case "tinyint":
+		return "int8"
+	case "boolean":
+		return "bool"
+	case "float":
+		return "float32"
+	case "double":
+		return "float64"
+	case "timestamp", "date":
+		m.IncludeTime = true
+		return "time.Time"
+	}
+
+	if udt := m.udt(elementType); udt != nil {
+		return m.udtGoType(udt)
+	}
+	log.Fatalf("Column %v with type %v was not mapped to a gocql value", name, cqlType)
+	return ""
+}
+
+// udt returns the user defined type named by cqlType, if there is one.
+func (m *_DAOModel) udt(cqlType string) *typeDef {
+	name := strings.Trim(cqlType[strings.LastIndex(cqlType, ".")+1:], `"`)
+	for _, t := range m.types {
+		if strings.EqualFold(strings.Trim(t.Name, `"`), name) {
+			return t
+		}
+	}
+	return nil
+}
+
+func (m *_DAOModel) udtGoType(udt *typeDef) string {
+	if m.ModelImport == "" {
+		return udt.GoName
+	}
+	return m.ModelImport + "." + udt.GoName
+}
+
+// namedQuery resolves a query declared in the config against the table's columns.
+// Parameters without a type take the type of the column with the same name.
+func (m *_DAOModel) namedQuery(def *queryDef) *namedQuery {
+	query := &namedQuery{Name: def.Name, Where: def.Where, Cardinality: def.Cardinality}
+	if def.Name == "" {
+		log.Fatalf("Table %v declared a query without a name", m.Table)
+	}
+
+	switch def.Cardinality {
+	case "one", "many":
+		if def.Where == "" {
+			log.Fatalf("Query %v for %v must declare a where fragment", def.Name, m.Table)
+		}
+		query.CQL = fmt.Sprintf("SELECT %v FROM %v.%v %v", m.InsertFields(), m.keyspace(), m.Table, def.Where)
+	case "exec":
+		if def.CQL != "" {
+			query.CQL = strings.Replace(def.CQL, "{table}", m.keyspace()+"."+m.Table, -1)
+		} else if def.Where != "" {
+			query.CQL = fmt.Sprintf("DELETE FROM %v.%v %v", m.keyspace(), m.Table, def.Where)
+		} else {
+			log.Fatalf("Query %v for %v must declare cql or a where fragment", def.Name, m.Table)
+		}
+	default:
+		log.Fatalf("Query %v for %v had unknown cardinality %v, expected one, many or exec", def.Name, m.Table, def.Cardinality)
+	}
+
+	query.CQL = strings.TrimSuffix(strings.TrimSpace(query.CQL), ";") + ";"
+	if strings.Count(query.CQL, "?") != len(def.Params) {
+		log.Fatalf("Query %v for %v binds %v parameters but declared %v", def.Name, m.Table, strings.Count(query.CQL, "?"), len(def.Params))
+	}
+
+	for _, p := range def.Params {
+		cqlType := p.CqlType
+		if cqlType == "" {
+			for _, c := range m.Columns {
+				if strings.EqualFold(c.Name, p.Name) {
+					cqlType = c.CqlType
+				}
+			}
+		}
+
+		if cqlType == "" {
+			log.Fatalf("Parameter %v of query %v for %v had no type and matched no column", p.Name, def.Name, m.Table)
+		}
+		goType, _, _ := (&_DAOModel{ModelImport: m.ModelImport, types: m.types}).goType(p.Name, cqlType)
+		query.Params = append(query.Params, &param{Name: paramName(p.Name), CqlType: cqlType, GoType: goType})
+	}
+	return query
+}
+
+// useSerializer records which serializer encodes a deserializeTo column so the
+// matching imports and helpers are generated. Anything other than json, gob,
+// protobuf or msgpack is treated as an expression for a user serializer.
+func (m *_DAOModel) useSerializer(column *param, serializer string) {
+	column.Serializer = serializer
+	switch serializer {
+	case "", "json":
+		column.Serializer = "json"
+		m.IncludeJson = true
+	case "gob":
+		m.IncludeGob = true
+	case "protobuf":
+		if !strings.HasPrefix(column.SerializedType, "*") {
+			log.Fatalf("Column %v uses the protobuf serializer so deserializeTo must be a pointer to a proto.Message, got %v", column.Name, column.SerializedType)
+		}
+		m.IncludeProtobuf = true
+	case "msgpack":
+		m.IncludeMsgpack = true
+	default:
+		m.IncludeCustomSerializer = true
+	}
+}
+
+// materializedView resolves a view of the table. The view selects every column under
+// its own primary key, which must hold the whole primary key of the table and at most one
+// other column, and is read through a DAO of its own that returns the table's model.
+func (m *_DAOModel) materializedView(def *viewDef) *_DAOModel {
+	if def.Name == "" || def.DAO == "" {
+		log.Fatalf("Table %v declared a view without a name or dao", m.Table)
+	}
+
+	view := *m
+	view.DAO, view.Table, view.view, view.Queries, view.views = def.DAO, def.Name, goName(def.Name), nil, nil
+	view.tableOptions, view.keyspaceOptions = nil, nil
+	view.partitioningKeys, view.clusteringKeys, view.clusteringOrder, view.keys = nil, nil, nil, nil
+
+	added := 0
+	for _, k := range def.Keys {
+		var column *param
+		for _, c := range m.Columns {
+			if c.Name == k.Name {
+				column = c
+			}
+		}
+
+		if column == nil {
+			log.Fatalf("View %v key %v is not a column of %v", def.Name, k.Name, m.Table)
+		} else if !FROZEN_REGEX.MatchString(column.CqlType) && (COLLECTION_REGEX.MatchString(column.CqlType) || MAP_REGEX.MatchString(column.CqlType)) {
+			log.Fatalf("View %v key %v is a collection, only frozen collections can be keys", def.Name, k.Name)
+		}
+
+		switch k.Key {
+		case "partition":
+			view.partitioningKeys = append(view.partitioningKeys, k.Name)
+		case "cluster", "cluster-asc", "cluster-desc":
+			view.clusteringKeys = append(view.clusteringKeys, k.Name)
+		default:
+			log.Fatalf("View %v key %v had unknown key %v, expected partition, cluster, cluster-asc or cluster-desc", def.Name, k.Name, k.Key)
+		}
+
+		switch k.Key {
+		case "cluster-asc":
+			view.clusteringOrder = append(view.clusteringOrder, k.Name+" ASC")
+		case "cluster-desc":
+			view.clusteringOrder = append(view.clusteringOrder, k.Name+" DESC")
+		}
+
+		view.keys = append(view.keys, k.Name)
+		if !contains(m.keys, k.Name) {
+			added++
+		}
+	}
+
+	if len(view.partitioningKeys) == 0 {
+		log.Fatalf("View %v must declare a partition key", def.Name)
+	} else if added > 1 {
+		log.Fatalf("View %v can add at most one column of %v to its primary key, it added %v", def.Name, m.Table, added)
+	}
+	for _, k := range m.keys {
+		if !contains(view.keys, k) {
+			log.Fatalf("View %v must include %v, every primary key column of %v must be part of its primary key", def.Name, k, m.Table)
+		}
+	}
+	return &view
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ViewDefinition renders the CREATE MATERIALIZED VIEW statement of a view selecting from table.
+func (m _DAOModel) ViewDefinition(table string) string {
+	conditions := make([]string, len(m.keys))
+	for i, k := range m.keys {
+		conditions[i] = k + " IS NOT NULL"
+	}
+
+	return fmt.Sprintf("CREATE MATERIALIZED VIEW IF NOT EXISTS %v.%v AS\n    SELECT %v FROM %v\n    WHERE %v\n    PRIMARY KEY (%v%v)%v;",
+		m.keyspace(), m.Table, m.InsertFields(), table, strings.Join(conditions, " AND "), m.PartitioningKeys(), m.ClusteringColumns(), m.ClusteringOrder())
+}
+
+// name prefixes the types and constants declared for the DAO, views share the model of
+// their table so they are named after the view.
+func (m _DAOModel) name() string {
+	if m.view != "" {
+		return m.view
+	}
+	return m.Model
 }
 
 func (m _DAOModel) InjectBoilerPlate() template.HTML {
@@ -255,26 +851,102 @@ func (m _DAOModel) InjectBoilerPlate() template.HTML {
 	return template.HTML(string(buff.Bytes()))
 }
 
+// BaseImports returns the imports the generated code needs, leaving out those the
+// additional imports or the boiler plate declare already.
 func (m _DAOModel) BaseImports() template.HTML {
-	res := []string{`"fmt"`}
-	if m.IncludeTime {
-		res = append(res, `"time"`)
+	std := []string{`"context"`, `"fmt"`, `"math"`, `"sync"`, `"time"`}
+	if m.view == "" {
+		std = append(std, `"errors"`)
+	}
+	if m.iterators {
+		std = append(std, `"iter"`)
 	}
-
 	if m.IncludeJson {
-		res = append(res, `"encoding/json"`)
+		std = append(std, `"encoding/json"`)
+	}
+	if m.IncludeGob {
+		std = append(std, `"bytes"`, `"encoding/gob"`)
+	}
+	if m.view == "" || m.RuntimeKeyspace {
+		std = append(std, `"strings"`)
+	}
+
+	external := []string{`"github.com/gocql/gocql"`}
+	if m.IncludeProtobuf {
+		external = append(external, `"google.golang.org/protobuf/proto"`)
+	}
+	if m.IncludeMsgpack {
+		external = append(external, `"github.com/vmihailenco/msgpack/v5"`)
+	}
+
+	declared := m.boilerPlateImports()
+	for _, im := range m.AdditionalImports {
+		declared[cleanImport(im)] = true
+	}
+	res := make([]string, 0)
+	for _, group := range [][]string{std, external} {
+		res = append(res, "")
+		for _, im := range group {
+			if !declared[im] {
+				res = append(res, im)
+			}
+		}
 	}
 	return template.HTML(strings.Join(res, "\n"))
 }
 
+// CleanAdditionalImports returns the additional imports the boiler plate does not declare.
 func (m _DAOModel) CleanAdditionalImports() template.HTML {
-	res := make([]string, len(m.AdditionalImports))
-	for i, im := range m.AdditionalImports {
-		res[i] = "  " + im
+	declared := m.boilerPlateImports()
+	res := make([]string, 0, len(m.AdditionalImports))
+	for _, im := range m.AdditionalImports {
+		if im = cleanImport(im); !declared[im] {
+			declared[im] = true
+			res = append(res, "  "+im)
+		}
 	}
 	return template.HTML(strings.Join(res, "\n"))
 }
 
+// boilerPlateImports returns the import specs the boiler plate declares, it is injected
+// right after the imports of the generated file so it may declare imports of its own.
+func (m _DAOModel) boilerPlateImports() map[string]bool {
+	res := make(map[string]bool)
+	if m.BoilerPlate == "" {
+		return res
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), m.BoilerPlate, "package p\n"+string(m.InjectBoilerPlate()), parser.ImportsOnly)
+	if err != nil {
+		log.Fatalf("Could not read the imports of boiler plate %v: %v", m.BoilerPlate, err)
+	}
+	for _, im := range f.Imports {
+		if im.Name != nil {
+			res[cleanImport(im.Name.Name+" "+im.Path.Value)] = true
+		} else {
+			res[im.Path.Value] = true
+		}
+	}
+	return res
+}
+
+// cleanImport trims an import spec and drops a name that repeats the package's own.
+func cleanImport(spec string) string {
+	fields := strings.Fields(spec)
+	if len(fields) == 2 {
+		if p, err := strconv.Unquote(fields[1]); err == nil && path.Base(p) == fields[0] {
+			return fields[1]
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// Iterators reports whether the All and AllRows iterators are generated, they need the
+// iter package of Go 1.23.
+func (m _DAOModel) Iterators() bool {
+	return m.iterators
+}
+
 func (m _DAOModel) ModelType() template.HTML {
 	if m.ModelImport == "" {
 		return template.HTML(m.Model)
@@ -286,6 +958,9 @@ func (m _DAOModel) TableDefinition() template.HTML {
 	params := make([]string, len(m.Columns))
 	for i, p := range m.Columns {
 		params[i] = fmt.Sprintf("    %v %v", p.Name, p.CqlType)
+		if contains(m.statics, p.Name) {
+			params[i] += " STATIC"
+		}
 	}
 	return template.HTML(strings.Join(params, ",\n"))
 }
@@ -307,17 +982,24 @@ func (m _DAOModel) ClusteringColumns() template.HTML {
 	return template.HTML(fmt.Sprintf(", %v", strings.Join(m.clusteringKeys, ", ")))
 }
 
+// ClusteringOrder renders the WITH clause of the table, its clustering order followed
+// by the table options.
 func (m _DAOModel) ClusteringOrder() template.HTML {
-	if len(m.clusteringOrder) == 0 {
+	clauses := m.tableOptions.clauses()
+	if len(m.clusteringOrder) != 0 {
+		clauses = append([]string{fmt.Sprintf("CLUSTERING ORDER BY (%v)", strings.Join(m.clusteringOrder, ", "))}, clauses...)
+	}
+
+	if len(clauses) == 0 {
 		return template.HTML("")
 	}
-	return template.HTML(fmt.Sprintf(" WITH CLUSTERING ORDER BY (%v)", strings.Join(m.clusteringOrder, ", ")))
+	return template.HTML(" WITH " + strings.Join(clauses, "\n    AND "))
 }
 
 func (m _DAOModel) GetScanParameters() template.HTML {
 	params := make([]string, len(m.Columns))
 	for i, p := range m.Columns {
-		params[i] = "&" + p.Name
+		params[i] = "&" + p.Field
 	}
 	return template.HTML(strings.Join(params, ", "))
 }
@@ -351,21 +1033,45 @@ func (m _DAOModel) InsertResource() template.HTML {
 	params := make([]string, len(m.Columns))
 	for i, p := range m.Columns {
 		if p.SerializedType == "" {
-			params[i] = "r." + p.Name
+			params[i] = "r." + p.Field
 		} else {
-			params[i] = p.Name
+			params[i] = p.Field
 		}
 	}
 	return template.HTML(strings.Join(params, ", "))
 }
 
+// cqlType returns the cql type of the column name.
+func (m _DAOModel) cqlType(name string) string {
+	for _, c := range m.Columns {
+		if c.Name == name {
+			return c.CqlType
+		}
+	}
+	return ""
+}
+
+// fields maps column names to the Go identifiers used for them.
+func (m _DAOModel) fields(names []string) []string {
+	fields := make([]string, len(names))
+	for i, name := range names {
+		fields[i] = name
+		for _, c := range m.Columns {
+			if c.Name == name {
+				fields[i] = c.Field
+			}
+		}
+	}
+	return fields
+}
+
 func (m _DAOModel) SelectSingleKeys() template.HTML {
-	return template.HTML(strings.Join(m.keys, ", "))
+	return template.HTML(strings.Join(m.fields(m.keys), ", "))
 }
 
 func (m _DAOModel) DeleteKeys() template.HTML {
 	keys := make([]string, len(m.keys))
-	for i, k := range m.keys {
+	for i, k := range m.fields(m.keys) {
 		keys[i] = "r." + k
 	}
 	return template.HTML(strings.Join(keys, ", "))
@@ -380,89 +1086,1441 @@ func (m _DAOModel) SelectSingle() template.HTML {
 }
 
 func (m _DAOModel) SelectListKeys() template.HTML {
-	return template.HTML(strings.Join(m.partitioningKeys, ", "))
+	return template.HTML(strings.Join(m.fields(m.partitioningKeys), ", "))
 }
 
-func (m _DAOModel) SelectList() template.HTML {
-	keys := make([]string, len(m.partitioningKeys))
-	for i, k := range m.partitioningKeys {
-		keys[i] = k + "=?"
-	}
-	return template.HTML(strings.Join(keys, " AND "))
+func (m _DAOModel) TokenKeys() template.HTML {
+	return template.HTML(fmt.Sprintf("token(%v)", strings.Join(m.partitioningKeys, ", ")))
 }
 
-func (m _DAOModel) CreateResourceFromParameters() template.HTML {
-	resource := make([]string, len(m.Columns))
+func (m _DAOModel) ScanVariables() template.HTML {
+	vars := make([]string, len(m.Columns))
 	for i, c := range m.Columns {
-		if c.SerializedType == "" {
-			resource[i] = fmt.Sprintf("          %v: %v", c.Name, c.Name)
-		} else if c.CqlType == "list<blob>" {
-			resource[i] = fmt.Sprintf("          %v: make([]%v, 0)", c.Name, c.SerializedType)
-		} else if c.CqlType == "map<text,blob>" {
-			resource[i] = fmt.Sprintf("          %v: make(map[string]%v)", c.Name, c.SerializedType)
-		}
+		vars[i] = fmt.Sprintf("    %v %v", c.Field, c.GoType)
 	}
-	return template.HTML(strings.Join(resource, ",\n") + ",")
+	return template.HTML(strings.Join(vars, "\n"))
 }
 
-func (m _DAOModel) DeserializeParameters() template.HTML {
-	deser := make([]string, 0)
-	for _, c := range m.Columns {
-		if c.SerializedType != "" {
-			if c.CqlType == "list<blob>" {
-				deser = append(deser, fmt.Sprintf(`
-    for _, v := range %v {
-      var value %v
-      if derr := json.Unmarshal(v, &value); derr != nil {
-        fmt.Println("Could not unmarshal value", derr, v)
-      }
-      resource.%v = append(resource.%v, value)
-    }`, c.Name, c.SerializedType, c.Name, c.Name))
-			} else if c.CqlType == "map<text,blob>" {
-				deser = append(deser, fmt.Sprintf(`
-    for k, v := range %v {
-      var value %v
-      if derr := json.Unmarshal(v, &value); derr != nil {
-        fmt.Println("Could not unmarshal value", derr, v)
-      }
-      resource.%v[k] = value
-    }`, c.Name, c.SerializedType, c.Name))
-			}
-		}
-	}
+func (m _DAOModel) HasKeyspaceOptions() bool {
+	return m.keyspaceOptions != nil
+}
 
-	if len(deser) == 0 {
-		return template.HTML("")
+// keyspace qualifies the statements, a placeholder replaced when the DAO is constructed
+// if the keyspace is picked at runtime.
+func (m _DAOModel) keyspace() string {
+	if m.RuntimeKeyspace {
+		return "{keyspace}"
 	}
+	return m.Keyspace
+}
 
-	return template.HTML(strings.Join(deser, "\n"))
+type statement struct {
+	Name string
+	CQL  string
 }
 
-func (m _DAOModel) SerializeParameters() template.HTML {
-	ser := make([]string, 0)
-	for _, c := range m.Columns {
-		if c.SerializedType != "" {
-			if c.CqlType == "list<blob>" {
-				ser = append(ser, fmt.Sprintf(`
-  %v := make([][]byte, 0)
-  for _, v := range r.%v {
-    if value, serr := json.Marshal(v); serr == nil {
-      %v = append(%v, value)
-    } else {
-      fmt.Println("Could not marshal value:", serr, v)
+// statements lists every statement the DAO runs.
+func (m _DAOModel) statements() []statement {
+	table := m.keyspace() + "." + m.Table
+	if m.view != "" {
+		return []statement{
+			{"Get", fmt.Sprintf("SELECT %v FROM %v WHERE %v;", m.InsertFields(), table, m.SelectSingle())},
+			{"List", fmt.Sprintf("SELECT %v FROM %v WHERE %v;", m.InsertFields(), table, m.SelectList())},
+			{"ListAll", fmt.Sprintf("SELECT %v FROM %v;", m.InsertFields(), table)},
+			{"ScanAll", fmt.Sprintf("SELECT %[1]v, %[2]v FROM %[3]v WHERE %[1]v > ? AND %[1]v <= ?;", m.TokenKeys(), m.InsertFields(), table)},
+		}
+	}
+
+	res := make([]statement, 0)
+	if m.keyspaceOptions != nil {
+		res = append(res, statement{"InitKeyspace", m.keyspaceOptions.definition(m.keyspace())})
+	}
+	for _, udt := range m.usedTypes() {
+		res = append(res, statement{"Type" + udt.GoName, m.TypeDefinition(udt)})
+	}
+	res = append(res, statement{"Init", fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (\n%v,\n\n    PRIMARY KEY (%v%v)\n  )%v;",
+		table, m.TableDefinition(), m.PartitioningKeys(), m.ClusteringColumns(), m.ClusteringOrder())})
+	if !m.counter {
+		res = append(res, statement{"Add", fmt.Sprintf("INSERT INTO %v (%v)\n                      VALUES (%v);", table, m.InsertFields(), m.InsertValues())})
+	}
+	res = append(res,
+		statement{"Get", fmt.Sprintf("SELECT %v FROM %v WHERE %v;", m.InsertFields(), table, m.SelectSingle())},
+		statement{"List", fmt.Sprintf("SELECT %v FROM %v WHERE %v;", m.InsertFields(), table, m.SelectList())},
+		statement{"ListAll", fmt.Sprintf("SELECT %v FROM %v;", m.InsertFields(), table)},
+		statement{"ScanAll", fmt.Sprintf("SELECT %[1]v, %[2]v FROM %[3]v WHERE %[1]v > ? AND %[1]v <= ?;", m.TokenKeys(), m.InsertFields(), table)},
+		statement{"Delete", fmt.Sprintf("DELETE FROM %v WHERE %v;", table, m.SelectSingle())},
+		statement{"DropTable", fmt.Sprintf("DROP TABLE IF EXISTS %v;", table)},
+	)
+	if len(m.statics) != 0 {
+		res = append(res, statement{"GetStatic", fmt.Sprintf("SELECT %v FROM %v WHERE %v LIMIT 1;", m.staticModel().InsertFields(), table, m.SelectList())})
+	}
+	if len(m.statics) != 0 && !m.counter {
+		assignments := make([]string, len(m.statics))
+		for i, s := range m.statics {
+			assignments[i] = s + " = ?"
+		}
+		res = append(res, statement{"SetStatic", fmt.Sprintf("UPDATE %v SET %v WHERE %v;", table, strings.Join(assignments, ", "), m.SelectList())})
+	}
+	if _, ok := m.keyTypes(); ok && len(m.clusteringKeys) != 0 {
+		keys := strings.Split(string(m.SelectSingle()), " AND ")
+		res = append(res, statement{"GetMany", fmt.Sprintf("SELECT %v FROM %v WHERE %v;", m.InsertFields(), table,
+			strings.Join(append(keys[:len(keys)-1], m.keys[len(m.keys)-1]+" IN ?"), " AND "))})
+	}
+	for _, c := range m.Counters() {
+		res = append(res, statement{"Increment" + c.Field, fmt.Sprintf("UPDATE %[1]v SET %[2]v = %[2]v + ? WHERE %[3]v;", table, c.Name, m.SelectSingle())})
+	}
+	for _, c := range m.Indexes() {
+		index := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %v ON %v (%v);", c.IndexName, table, c.IndexTarget)
+		if c.Index == "sai" {
+			index = fmt.Sprintf("CREATE CUSTOM INDEX IF NOT EXISTS %v ON %v (%v) USING 'StorageAttachedIndex';", c.IndexName, table, c.IndexTarget)
+		}
+		res = append(res,
+			statement{"Index" + c.Field, index},
+			statement{"ListBy" + c.Field, fmt.Sprintf("SELECT %v FROM %v WHERE %v %v ?;", m.InsertFields(), table, c.Name, c.IndexOperator)})
+	}
+	for _, v := range m.views {
+		res = append(res,
+			statement{"View" + v.view, v.ViewDefinition(table)},
+			statement{"DropView" + v.view, fmt.Sprintf("DROP MATERIALIZED VIEW IF EXISTS %v.%v;", v.keyspace(), v.Table)})
+	}
+	for _, q := range m.Queries {
+		res = append(res, statement{q.Name, q.CQL})
+	}
+	return res
+}
+
+func (m _DAOModel) statementConstant(name string) string {
+	return fmt.Sprintf("%v%vStatement", lowerName(m.name()), name)
+}
+
+// Statement renders the statement a method runs, a literal unless the keyspace is
+// picked at runtime.
+func (m _DAOModel) Statement(name string) template.HTML {
+	for _, s := range m.statements() {
+		if s.Name != name {
+			continue
+		} else if m.RuntimeKeyspace {
+			return template.HTML(fmt.Sprintf("dao.%vStatements.statement(%v)", m.name(), m.statementConstant(name)))
+		}
+		return template.HTML("`" + s.CQL + "`")
+	}
+	log.Fatalf("Table %v has no %v statement", m.Table, name)
+	return ""
+}
+
+func (m _DAOModel) RuntimeStatements() template.HTML {
+	if !m.RuntimeKeyspace {
+		return ""
+	}
+
+	statements := m.statements()
+	constants, literals := make([]string, len(statements)), make([]string, len(statements))
+	for i, s := range statements {
+		constants[i] = "  " + m.statementConstant(s.Name)
+		literals[i] = "  `" + s.CQL + "`,"
+	}
+	constants[0] += " = iota"
+
+	return template.HTML(fmt.Sprintf(`
+const (
+%[4]v
+  %[2]vStatementCount
+)
+
+// %[2]vStatements are the statements of %[3]v, {keyspace} is replaced by the keyspace
+// the DAO is constructed for.
+var %[2]vStatements = [%[2]vStatementCount]string{
+%[5]v
+}
+
+var default%[1]vStatements = New%[1]vStatements("%[6]v")
+
+// %[1]vStatements holds the statements of %[3]v prepared for one keyspace. Embed it in
+// %[3]v and set it with New%[1]vStatements to pick the keyspace when the DAO is
+// constructed, the zero value uses the %[6]v keyspace.
+type %[1]vStatements struct {
+  keyspace   string
+  statements *[%[2]vStatementCount]string
+}
+
+// New%[1]vStatements prepares the statements of %[3]v for keyspace.
+func New%[1]vStatements(keyspace string) %[1]vStatements {
+  statements := new([%[2]vStatementCount]string)
+  for i, cql := range %[2]vStatements {
+    statements[i] = strings.Replace(cql, "{keyspace}", keyspace, -1)
+  }
+  return %[1]vStatements{keyspace: keyspace, statements: statements}
+}
+
+// Keyspace returns the keyspace the statements run in.
+func (s %[1]vStatements) Keyspace() string {
+  if s.statements == nil {
+    return default%[1]vStatements.keyspace
+  }
+  return s.keyspace
+}
+
+func (s %[1]vStatements) statement(i int) string {
+  if s.statements == nil {
+    return default%[1]vStatements.statements[i]
+  }
+  return s.statements[i]
+}
+`, m.name(), lowerName(m.name()), m.DAO, strings.Join(constants, "\n"), strings.Join(literals, "\n"), m.Keyspace))
+}
+
+func (m _DAOModel) TokenScan() template.HTML {
+	var collectDecl, reportDecl, collectParam, collectArg, result string
+	if m.LenientSerialization() {
+		collectDecl = fmt.Sprintf(`
+  var (
+    failures sync.Mutex
+    report   *%[1]vSerializationReport
+  )
+  collect := func(r *%[1]vSerializationReport) {
+    failures.Lock()
+    defer failures.Unlock()
+    for _, f := range r.Failures {
+      report = report.add(f)
     }
-  }`, c.Name, c.Name, c.Name, c.Name))
-			} else if c.CqlType == "map<text,blob>" {
-				ser = append(ser, fmt.Sprintf(`
-  %v := make(map[string][]byte)
-  for k, v := range r.%v {
-    if value, serr := json.Marshal(v); serr == nil {
-      %v[k] = value
+  }
+`, m.Model)
+		reportDecl = fmt.Sprintf(`
+  var report *%vSerializationReport
+  defer func() {
+    if report != nil {
+      collect(report)
+    }
+  }()
+`, m.Model)
+		collectParam = fmt.Sprintf(", collect func(*%vSerializationReport)", m.Model)
+		collectArg = ", collect"
+		result = "report.err()"
+	} else {
+		result = "nil"
+	}
+
+	return template.HTML(fmt.Sprintf(`
+// %[17]vScanOptions tunes ScanAllWithOptions, fields left zero take the defaults of ScanAll.
+type %[17]vScanOptions struct {
+  // Parallelism is the number of workers querying token ranges concurrently, 1 by default.
+  Parallelism int
+  // Splits is the number of ranges the token ring is split into, 16 per worker by default.
+  Splits int
+  // Attempts is how many times a failing range is read before the scan fails, 3 by default.
+  Attempts int
+  // OnRetry, when set, is called with the error of a range before it is read again.
+  OnRetry func(lower, upper int64, err error)
+}
+
+// ScanAll reads the whole table by splitting the Murmur3 token ring into ranges that
+// parallelism workers query concurrently, retrying a failed range from the last partition
+// it finished. fn is called from several goroutines and the first error it returns stops
+// the scan. Rows of the partition being read when a range fails may be passed to fn twice.
+func (dao *%[1]v) ScanAll(ctx context.Context, parallelism int, fn func(*%[2]v) error, _session ...*gocql.Session) error {
+  return dao.ScanAllWithOptions(ctx, %[17]vScanOptions{Parallelism: parallelism}, fn, _session...)
+}
+
+// ScanAllWithOptions is ScanAll with the number of workers, token ranges and attempts
+// of a range set by options.
+func (dao *%[1]v) ScanAllWithOptions(ctx context.Context, options %[17]vScanOptions, fn func(*%[2]v) error, _session ...*gocql.Session) error {
+  session, err, closeSession := dao.session(_session...)
+  if err != nil {
+    return err
+  } else if closeSession {
+    defer session.Close()
+  }
+
+  if options.Parallelism < 1 {
+    options.Parallelism = 1
+  }
+  if options.Splits < 1 {
+    options.Splits = options.Parallelism * 16
+  }
+  if options.Attempts < 1 {
+    options.Attempts = 3
+  }
+
+  scanCtx, cancel := context.WithCancel(ctx)
+  defer cancel()
+  %[12]v
+  ranges := make(chan [2]int64)
+  go func() {
+    defer close(ranges)
+    for _, r := range dao.tokenRanges(options.Splits) {
+      select {
+      case ranges <- r:
+      case <-scanCtx.Done():
+        return
+      }
+    }
+  }()
+
+  errs := make(chan error, options.Parallelism)
+  var workers sync.WaitGroup
+  for w := 0; w < options.Parallelism; w++ {
+    workers.Add(1)
+    go func() {
+      defer workers.Done()
+      for r := range ranges {
+        if err := dao.scanRange(scanCtx, session, options, r[0], r[1], fn%[15]v); err != nil {
+          errs <- err
+          cancel()
+          return
+        }
+      }
+    }()
+  }
+  workers.Wait()
+
+  select {
+  case err := <-errs:
+    return err
+  default:
+  }
+  if err := ctx.Err(); err != nil {
+    return err
+  }
+  return %[16]v
+}
+
+
+func (dao *%[1]v) tokenRanges(splits int) [][2]int64 {
+  min := int64(math.MinInt64)
+  step := math.MaxUint64 / uint64(splits)
+  ranges := make([][2]int64, splits)
+  for i := range ranges {
+    ranges[i][0] = int64(uint64(min) + uint64(i)*step)
+    ranges[i][1] = int64(uint64(min) + uint64(i+1)*step)
+  }
+  ranges[splits-1][1] = math.MaxInt64
+  return ranges
+}
+
+func (dao *%[1]v) scanRange(ctx context.Context, session *gocql.Session, options %[17]vScanOptions, lower, upper int64, fn func(*%[2]v) error%[14]v) (err error) {
+  for attempt := 1; ; attempt++ {
+    var retry bool
+    if lower, retry, err = dao.scanTokens(ctx, session, lower, upper, fn%[15]v); err == nil || !retry {
+      return err
+    } else if attempt >= options.Attempts {
+      return fmt.Errorf("scanning tokens %%v to %%v of %[4]v failed %%v times: %%w", lower, upper, attempt, err)
+    } else if options.OnRetry != nil {
+      options.OnRetry(lower, upper, err)
+    }
+
+    select {
+    case <-ctx.Done():
+      return ctx.Err()
+    case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+    }
+  }
+}
+
+func (dao *%[1]v) scanTokens(ctx context.Context, session *gocql.Session, lower, upper int64, fn func(*%[2]v) error%[14]v) (int64, bool, error) {
+  var (
+    rowToken int64
+%[8]v
+  )
+  %[13]v
+  iter := session.Query(%[5]v, lower, upper).
+    WithContext(ctx).PageSize(dao.pageSize()).Iter()
+  resume, current := lower, lower
+  for iter.Scan(&rowToken, %[9]v) {
+    if rowToken != current {
+      resume, current = current, rowToken
+    }
+
+    resource := &%[2]v{
+%[10]v
+    }
+    %[11]v
+
+    if err := fn(resource); err != nil {
+      iter.Close()
+      return resume, false, err
+    }
+  }
+
+  if err := iter.Close(); err != nil {
+    return resume, ctx.Err() == nil, err
+  }
+  return upper, false, nil
+}
+`, m.DAO, m.ModelType(), m.Model, m.Table, m.Statement("ScanAll"), m.TokenKeys(), m.InsertFields(),
+		m.ScanVariables(), m.GetScanParameters(), m.CreateResourceFromParameters(), m.DeserializeParameters("scan"),
+		collectDecl, reportDecl, collectParam, collectArg, result, m.name()))
+}
+
+func (m _DAOModel) StreamHandle() template.HTML {
+	return template.HTML(fmt.Sprintf(`
+// %[1]vStreamHandle delivers streamed rows on Rows, which is closed once the query finishes,
+// fails or the handle is closed. Errors that end the stream are reported by Err and Close.
+type %[1]vStreamHandle struct {
+  Rows <-chan *%[1]vStream
+
+  cancel context.CancelFunc
+  done   chan struct{}
+  err    error
+}
+
+// Err waits for Rows to be closed and returns the error that ended the stream, if any.
+func (h *%[1]vStreamHandle) Err() error {
+  <-h.done
+  return h.err
+}
+
+// Close stops the query, releases its session and returns the error that ended the
+// stream, if any. It is safe to call Close before Rows has been drained.
+func (h *%[1]vStreamHandle) Close() error {
+  h.cancel()
+  for range h.Rows {
+  }
+  <-h.done
+  if errors.Is(h.err, context.Canceled) {
+    return nil
+  }
+  return h.err
+}
+%[2]v`, m.Model, m.StreamProducer()))
+}
+
+// StreamProducer renders the methods feeding a stream handle, views declare them for
+// the handle of their table.
+func (m _DAOModel) StreamProducer() template.HTML {
+	var reportDecl, result string
+	if m.LenientSerialization() {
+		reportDecl = fmt.Sprintf(`
+    var report *%vSerializationReport`, m.Model)
+		result = "report.err()"
+	} else {
+		result = "nil"
+	}
+
+	return template.HTML(fmt.Sprintf(`
+func (dao *%[1]v) streamContext(ctx context.Context, cql string, params ...interface{}) *%[3]vStreamHandle {
+  ctx, cancel := context.WithCancel(ctx)
+  rows := make(chan *%[3]vStream, dao.capacity())
+  handle := &%[3]vStreamHandle{Rows: rows, cancel: cancel, done: make(chan struct{})}
+
+  go func() {
+    defer close(handle.done)
+    defer close(rows)
+    defer cancel()
+    handle.err = dao.produce(ctx, rows, cql, params...)
+  }()
+
+  return handle
+}
+
+func (dao *%[1]v) produce(ctx context.Context, rows chan<- *%[3]vStream, cql string, params ...interface{}) error {
+  session, err := dao.createSession()
+  if err != nil {
+    return err
+  }
+  defer session.Close()
+
+  var (
+%[4]v
+  )
+
+  iter := session.Query(cql, params...).WithContext(ctx).PageSize(dao.pageSize()).Iter()
+  for iter.Scan(%[5]v) {
+    resource := &%[2]v{
+%[6]v
+    }
+    %[8]v
+    %[7]v
+
+    select {
+    case rows <- &%[3]vStream{DTO: resource, ERR: %[9]v}:
+    case <-ctx.Done():
+      iter.Close()
+      return ctx.Err()
+    }
+  }
+
+  return iter.Close()
+}
+`, m.DAO, m.ModelType(), m.Model, m.ScanVariables(), m.GetScanParameters(), m.CreateResourceFromParameters(),
+		m.DeserializeParameters("cursor"), reportDecl, result))
+}
+
+// useIndex validates the index of a column and resolves how lookups through it compare
+// values: collections are searched for the elements they contain, frozen values whole.
+func (m *_DAOModel) useIndex(c *param, index, name string) {
+	if c.SerializedType != "" {
+		log.Fatalf("Column %v holds serialized blobs and can not be indexed", c.Name)
+	}
+
+	c.Index, c.IndexName, c.IndexTarget, c.IndexOperator, c.IndexGoType = index, name, c.Name, "=", c.GoType
+	if c.IndexName == "" {
+		c.IndexName = strings.ToLower(fmt.Sprintf("%v_%v_idx", m.Table, strings.Trim(c.Name, `"`)))
+	}
+
+	if FROZEN_REGEX.MatchString(c.CqlType) && m.udt(c.CqlType) == nil {
+		c.IndexTarget = fmt.Sprintf("FULL(%v)", c.Name)
+	} else if match := MAP_REGEX.FindStringSubmatch(c.CqlType); match != nil {
+		c.IndexTarget = fmt.Sprintf("VALUES(%v)", c.Name)
+		c.IndexOperator, c.IndexGoType = "CONTAINS", m.elementType(c.Name, c.CqlType, match[2])
+	} else if match := COLLECTION_REGEX.FindStringSubmatch(c.CqlType); len(match) == 3 {
+		c.IndexOperator, c.IndexGoType = "CONTAINS", m.elementType(c.Name, c.CqlType, match[1]+match[2])
+	}
+}
+
+// useCounters detects counter tables, whose columns outside the primary key are all
+// counters. Cassandra keeps counters in tables of their own, so mixing them with other
+// columns is refused.
+func (m *_DAOModel) useCounters() {
+	counters, regular := make([]string, 0), make([]string, 0)
+	for _, c := range m.Columns {
+		if c.CqlType == "counter" && contains(m.keys, c.Name) {
+			log.Fatalf("Column %v of %v is a counter, counters can not be part of the primary key", c.Name, m.Table)
+		} else if c.CqlType == "counter" {
+			if c.Index != "" {
+				log.Fatalf("Column %v of %v is a counter, counters can not be indexed", c.Name, m.Table)
+			}
+			counters = append(counters, c.Name)
+		} else if !contains(m.keys, c.Name) {
+			regular = append(regular, c.Name)
+		}
+	}
+
+	if len(counters) != 0 && len(regular) != 0 {
+		log.Fatalf("Table %v mixes the counters %v with the columns %v, counters need a table of their own", m.Table, strings.Join(counters, ", "), strings.Join(regular, ", "))
+	}
+	m.counter = len(counters) != 0
+}
+
+// Counters returns the counter columns.
+func (m _DAOModel) Counters() []*param {
+	res := make([]*param, 0)
+	for _, c := range m.Columns {
+		if c.CqlType == "counter" {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func (m _DAOModel) CounterTable() bool {
+	return m.counter
+}
+
+func (m _DAOModel) CounterMethods() template.HTML {
+	if !m.counter {
+		return ""
+	}
+
+	// the statements of a DAO picking its keyspace at runtime are read through it
+	dao := ""
+	if m.RuntimeKeyspace {
+		dao = "\n  dao := b.dao"
+	}
+
+	methods := make([]string, 0)
+	for _, c := range m.Counters() {
+		methods = append(methods, fmt.Sprintf(`
+// Increment%[1]v adds delta to the %[2]v counter of the row.
+func (dao *%[3]v) Increment%[1]v(%[4]v interface{}, delta int64, _session ...*gocql.Session) error {
+  session, err, close := dao.session(_session...)
+  if err != nil {
+    return err
+  } else if close {
+    defer session.Close()
+  }
+
+  return session.Query(%[5]v, delta, %[4]v).Exec()
+}
+
+// Decrement%[1]v subtracts delta from the %[2]v counter of the row.
+func (dao *%[3]v) Decrement%[1]v(%[4]v interface{}, delta int64, _session ...*gocql.Session) error {
+  return dao.Increment%[1]v(%[4]v, -delta, _session...)
+}
+
+// Increment%[1]v adds delta to the %[2]v counter of the row when the batch runs.
+func (b *%[6]vCounterBatch) Increment%[1]v(%[4]v interface{}, delta int64) *%[6]vCounterBatch {%[7]v
+  b.queries = append(b.queries, %[5]v)
+  b.params = append(b.params, []interface{}{delta, %[4]v})
+  return b
+}
+
+// Decrement%[1]v subtracts delta from the %[2]v counter of the row when the batch runs.
+func (b *%[6]vCounterBatch) Decrement%[1]v(%[4]v interface{}, delta int64) *%[6]vCounterBatch {
+  return b.Increment%[1]v(%[4]v, -delta)
+}
+`, c.Field, c.Name, m.DAO, m.SelectSingleKeys(), m.Statement("Increment"+c.Field), m.Model, dao))
+	}
+
+	return template.HTML(fmt.Sprintf(`
+// %[1]vCounterBatch collects counter updates of %[2]v that Exec applies in one counter batch.
+type %[1]vCounterBatch struct {
+  dao     *%[3]v
+  queries []string
+  params  [][]interface{}
+}
+
+// CounterBatch starts an empty batch of counter updates.
+func (dao *%[3]v) CounterBatch() *%[1]vCounterBatch {
+  return &%[1]vCounterBatch{dao: dao}
+}
+
+// Exec applies the updates of the batch.
+func (b *%[1]vCounterBatch) Exec(_session ...*gocql.Session) error {
+  session, err, close := b.dao.session(_session...)
+  if err != nil {
+    return err
+  } else if close {
+    defer session.Close()
+  }
+
+  batch := session.NewBatch(gocql.CounterBatch)
+  for i, cql := range b.queries {
+    batch.Query(cql, b.params[i]...)
+  }
+  return session.ExecuteBatch(batch)
+}
+%[4]v`, m.Model, m.Table, m.DAO, strings.Join(methods, "")))
+}
+
+// keyTypes returns the Go types of the primary key columns as values, GetMany needs them to
+// be comparable to key its result.
+func (m _DAOModel) keyTypes() ([]string, bool) {
+	types := make([]string, len(m.keys))
+	for i, k := range m.keys {
+		for _, c := range m.Columns {
+			if c.Name != k {
+				continue
+			} else if c.GoType == "*gocql.UUID" || c.GoType == "*time.Time" {
+				types[i] = c.GoType[1:]
+			} else if strings.ContainsAny(c.GoType, "*[") {
+				return nil, false
+			} else {
+				types[i] = c.GoType
+			}
+		}
+	}
+	return types, true
+}
+
+// GetMany renders the Key type of the table and GetMany, unless a primary key column is
+// not comparable. Keys that only differ in their last clustering column are read by one IN
+// query, the other keys by a query each, and the queries run on a bounded pool of workers.
+func (m _DAOModel) GetMany() template.HTML {
+	types, ok := m.keyTypes()
+	if !ok {
+		return ""
+	}
+
+	fields := m.fields(m.keys)
+	declarations, normalize, row, args := make([]string, len(fields)), make([]string, 0), make([]string, len(fields)), make([]string, len(fields))
+	for i, f := range fields {
+		declarations[i] = fmt.Sprintf("  %v %v", f, types[i])
+		args[i] = "group." + f
+		row[i] = fmt.Sprintf("%[1]v: r.%[1]v", f)
+		if types[i] == "time.Time" || types[i] == "gocql.UUID" {
+			row[i] = fmt.Sprintf("%[1]v: *r.%[1]v", f)
+		}
+		if types[i] == "time.Time" && m.cqlType(m.keys[i]) == "date" {
+			normalize = append(normalize, fmt.Sprintf("  k.%[1]v = k.%[1]v.UTC().Truncate(24 * time.Hour)\n", f))
+		} else if types[i] == "time.Time" {
+			normalize = append(normalize, fmt.Sprintf("  k.%[1]v = time.UnixMilli(k.%[1]v.UnixMilli()).UTC()\n", f))
+		}
+	}
+
+	statement, group, in := m.Statement("Get"), "", ""
+	if len(m.clusteringKeys) != 0 {
+		last := fields[len(fields)-1]
+		statement, args[len(args)-1] = m.Statement("GetMany"), "in"
+		group = fmt.Sprintf(`
+    var zero %[1]vKey
+    group.%[2]v = zero.%[2]v`, m.Model, last)
+		in = fmt.Sprintf(`
+        in := make([]%[1]v, len(requested))
+        for i, k := range requested {
+          in[i] = k.%[2]v
+        }`, types[len(types)-1], last)
+	}
+
+	return template.HTML(fmt.Sprintf(`
+// %[1]vKey is the primary key of a row of %[2]v.
+type %[1]vKey struct {
+%[3]v
+}
+
+// normalized truncates timestamps to the milliseconds and dates to the days Cassandra
+// stores, in UTC.
+func (k %[1]vKey) normalized() %[1]vKey {
+%[4]v  return k
+}
+
+// %[1]vGetManyOptions tunes GetManyWithOptions, fields left zero take the defaults of GetMany.
+type %[1]vGetManyOptions struct {
+  // Workers is the number of queries run concurrently, 16 by default.
+  Workers int
+}
+
+// GetMany returns the rows of keys that exist, keyed by the key they were requested with.
+// Keys that only differ in their last clustering column are read by one IN query, the
+// queries run concurrently on at most 16 workers.
+func (dao *%[5]v) GetMany(keys []%[1]vKey, _session ...*gocql.Session) (map[%[1]vKey]*%[6]v, error) {
+  return dao.GetManyWithOptions(keys, %[1]vGetManyOptions{}, _session...)
+}
+
+// GetManyWithOptions is GetMany with the number of workers set by options.
+func (dao *%[5]v) GetManyWithOptions(keys []%[1]vKey, options %[1]vGetManyOptions, _session ...*gocql.Session) (map[%[1]vKey]*%[6]v, error) {
+  session, err, closeSession := dao.session(_session...)
+  if err != nil {
+    return nil, err
+  } else if closeSession {
+    defer session.Close()
+  }
+
+  if options.Workers < 1 {
+    options.Workers = 16
+  }
+
+  groups, order := make(map[%[1]vKey][]%[1]vKey), make([]%[1]vKey, 0)
+  for _, k := range keys {
+    group := k.normalized()%[7]v
+    if _, ok := groups[group]; !ok {
+      order = append(order, group)
+    }
+    groups[group] = append(groups[group], k)
+  }
+
+  var (
+    lock    sync.Mutex
+    failed  error
+    workers sync.WaitGroup
+  )
+  res := make(map[%[1]vKey]*%[6]v, len(keys))
+  work := make(chan %[1]vKey)
+  for w := 0; w != options.Workers && w != len(order); w++ {
+    workers.Add(1)
+    go func() {
+      defer workers.Done()
+      for group := range work {
+        requested := groups[group]%[8]v
+        rows, err := dao.list(session, %[9]v, %[10]v)
+
+        lock.Lock()
+        if err != nil && failed == nil {
+          failed = err
+        }
+        for _, r := range rows {
+          key := %[1]vKey{%[11]v}.normalized()
+          for _, k := range requested {
+            if k.normalized() == key {
+              res[k] = r
+            }
+          }
+        }
+        lock.Unlock()
+      }
+    }()
+  }
+
+  for _, group := range order {
+    work <- group
+  }
+  close(work)
+  workers.Wait()
+  return res, failed
+}
+`, m.Model, m.Table, strings.Join(declarations, "\n"), strings.Join(normalize, ""), m.DAO, m.ModelType(), group, in,
+		statement, strings.Join(args, ", "), strings.Join(row, ", ")))
+}
+
+// staticModel returns the model of the partition key and static columns of a partition.
+func (m _DAOModel) staticModel() _DAOModel {
+	static := m
+	static.Columns = make([]*param, 0, len(m.partitioningKeys)+len(m.statics))
+	for _, c := range m.Columns {
+		if contains(m.partitioningKeys, c.Name) || contains(m.statics, c.Name) {
+			static.Columns = append(static.Columns, c)
+		}
+	}
+	return static
+}
+
+// StaticMethods renders the methods reading and writing the static columns of a partition.
+func (m _DAOModel) StaticMethods() template.HTML {
+	if len(m.statics) == 0 {
+		return ""
+	}
+
+	static := m.staticModel()
+	fields, locals := make([]string, len(static.Columns)), make([]string, len(static.Columns))
+	for i, c := range static.Columns {
+		fields[i] = fmt.Sprintf("  %v %v", c.Field, c.GoType)
+		if c.SerializedType != "" {
+			fields[i] = fmt.Sprintf("  %v %v", c.Field, c.serializedGoType(c.SerializedType))
+		}
+		locals[i] = fmt.Sprintf("    %v %v", c.Field, c.GoType)
+	}
+
+	report, result := "", "nil"
+	if static.LenientSerialization() {
+		report, result = fmt.Sprintf(`
+  var report *%vSerializationReport`, m.Model), "report.err()"
+	}
+
+	res := fmt.Sprintf(`
+// %[3]vStatic holds the partition key and the static columns of a partition of %[8]v.
+type %[3]vStatic struct {
+%[4]v
+}
+
+// GetStatic returns the static columns of the partition, nil when the partition has no rows.
+func (dao *%[1]v) GetStatic(%[2]v interface{}, _session ...*gocql.Session) (*%[3]vStatic, error) {
+  session, err, close := dao.session(_session...)
+  if err != nil {
+    return nil, err
+  } else if close {
+    defer session.Close()
+  }
+
+  return dao.getStatic(session.Query(%[6]v, %[2]v))
+}
+
+// getStatic scans the static columns read by query.
+func (dao *%[1]v) getStatic(query *gocql.Query) (*%[3]vStatic, error) {
+  var (
+%[5]v
+  )
+  if err := query.Scan(%[7]v); err == gocql.ErrNotFound {
+    return nil, nil
+  } else if err != nil {
+    return nil, err
+  }
+  %[9]v
+  resource := &%[3]vStatic{
+%[10]v
+  }
+  %[11]v
+  return resource, %[12]v
+}
+`, m.DAO, m.SelectListKeys(), m.Model, strings.Join(fields, "\n"), strings.Join(locals, "\n"), m.Statement("GetStatic"), static.GetScanParameters(), m.Table,
+		report, static.CreateResourceFromParameters(), static.DeserializeParameters("get"), result)
+	if m.counter {
+		return template.HTML(res)
+	}
+
+	values := make([]string, 0, len(m.statics)+len(m.partitioningKeys))
+	for _, c := range append(m.params(m.statics), m.params(m.partitioningKeys)...) {
+		if c.SerializedType == "" {
+			values = append(values, "r."+c.Field)
+		} else {
+			values = append(values, c.Field)
+		}
+	}
+
+	statics := static
+	statics.Columns = m.params(m.statics)
+	return template.HTML(res + fmt.Sprintf(`
+// SetStatic writes the static columns %[2]v of the partition of r, leaving its rows untouched.
+func (dao *%[1]v) SetStatic(r *%[3]v, _session ...*gocql.Session) error {
+  session, err, close := dao.session(_session...)
+  if err != nil {
+    return err
+  } else if close {
+    defer session.Close()
+  }
+  %[6]v
+  %[7]v
+  if err := session.Query(%[4]v, %[5]v).Exec(); err != nil {
+    return err
+  }
+  return %[8]v
+}
+`, m.DAO, strings.Join(m.statics, ", "), m.ModelType(), m.Statement("SetStatic"), strings.Join(values, ", "),
+		report, statics.serializeParameters("set"), result))
+}
+
+// params returns the columns named by names, in that order.
+func (m _DAOModel) params(names []string) []*param {
+	res := make([]*param, 0, len(names))
+	for _, name := range names {
+		for _, c := range m.Columns {
+			if c.Name == name {
+				res = append(res, c)
+			}
+		}
+	}
+	return res
+}
+
+// Indexes returns the indexed columns.
+func (m _DAOModel) Indexes() []*param {
+	res := make([]*param, 0)
+	for _, c := range m.Columns {
+		if c.Index != "" {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// HasDependents reports whether Init creates indexes or views after the table.
+func (m _DAOModel) HasDependents() bool {
+	return len(m.Indexes()) != 0 || len(m.views) != 0
+}
+
+func (m _DAOModel) CreateDependents() template.HTML {
+	dependents := make([]string, 0)
+	for _, c := range m.Indexes() {
+		dependents = append(dependents, fmt.Sprintf(`  if err := session.Query(%v).Exec(); err != nil {
+    return err
+  }`, m.Statement("Index"+c.Field)))
+	}
+	for _, v := range m.views {
+		dependents = append(dependents, fmt.Sprintf(`  if err := session.Query(%v).Exec(); err != nil {
+    return err
+  }`, m.Statement("View"+v.view)))
+	}
+	return template.HTML(strings.Join(dependents, "\n"))
+}
+
+// DropViews drops the views of the table, Cassandra refuses to drop a table that has views.
+func (m _DAOModel) DropViews() template.HTML {
+	views := make([]string, len(m.views))
+	for i, v := range m.views {
+		views[i] = fmt.Sprintf(`  if err := session.Query(%v).Exec(); err != nil {
+    return err
+  }
+`, m.Statement("DropView"+v.view))
+	}
+	return template.HTML(strings.Join(views, ""))
+}
+
+func (m _DAOModel) IndexQueries() template.HTML {
+	methods := make([]string, 0)
+	for _, c := range m.Indexes() {
+		value, compares := paramName(c.Name), "equals"
+		if c.IndexOperator == "CONTAINS" {
+			compares = "contains"
+		}
+		methods = append(methods, fmt.Sprintf(`
+// ListBy%[1]v returns the rows whose %[2]v %[3]v the value, looked up through the %[4]v index.
+func (dao *%[5]v) ListBy%[1]v(%[6]v %[7]v, _session ...*gocql.Session) ([]*%[8]v, error) {
+  session, err, close := dao.session(_session...)
+  if err != nil {
+    return nil, err
+  } else if close {
+    defer session.Close()
+  }
+
+  return dao.list(session, %[9]v, %[6]v)
+}
+
+// StreamBy%[1]v streams the rows whose %[2]v %[3]v the value until they are exhausted, ctx
+// is done or the handle is closed.
+func (dao *%[5]v) StreamBy%[1]v(ctx context.Context, %[6]v %[7]v) *%[10]vStreamHandle {
+  return dao.streamContext(ctx, %[9]v, %[6]v)
+}
+`, c.Field, c.Name, compares, c.IndexName, m.DAO, value, c.IndexGoType, m.ModelType(), m.Statement("ListBy"+c.Field), m.Model))
+	}
+	return template.HTML(strings.Join(methods, ""))
+}
+
+var VARCHAR_REGEX = regexp.MustCompile(`\bvarchar\b`)
+
+// schemaName is the name system_schema stores for a cql identifier, unquoted names are
+// case insensitive and stored lower case.
+func schemaName(name string) string {
+	if strings.HasPrefix(name, `"`) {
+		return strings.Trim(name, `"`)
+	}
+	return strings.ToLower(name)
+}
+
+// schemaType is the type system_schema stores for a cql type, without spaces.
+func schemaType(cqlType string) string {
+	return VARCHAR_REGEX.ReplaceAllString(strings.ToLower(strings.Replace(cqlType, " ", "", -1)), "text")
+}
+
+func (m _DAOModel) VerifySchema() template.HTML {
+	keyspace, normalize := fmt.Sprintf("%q", schemaName(m.Keyspace)), ""
+	if m.RuntimeKeyspace {
+		// the keyspace is only known at runtime, name it the way system_schema does there
+		keyspace = fmt.Sprintf("dao.%vStatements.Keyspace()", m.name())
+		normalize = "\n  if strings.HasPrefix(keyspace, `\"`) {\n    keyspace = strings.Trim(keyspace, `\"`)\n  } else {\n    keyspace = strings.ToLower(keyspace)\n  }"
+	}
+
+	columns := make([]string, len(m.Columns))
+	for i, c := range m.Columns {
+		kind, position, order := "regular", -1, "none"
+		if contains(m.statics, c.Name) {
+			kind = "static"
+		}
+		for j, k := range m.partitioningKeys {
+			if k == c.Name {
+				kind, position = "partition_key", j
+			}
+		}
+		for j, k := range m.clusteringKeys {
+			if k == c.Name {
+				kind, position, order = "clustering", j, "asc"
+				if contains(m.clusteringOrder, k+" DESC") {
+					order = "desc"
+				}
+			}
+		}
+		columns[i] = fmt.Sprintf("    {%q, %q, %q, %v, %q},", schemaName(c.Name), schemaType(c.CqlType), kind, position, order)
+	}
+
+	return template.HTML(fmt.Sprintf(`
+// VerifySchema compares the table in system_schema with the schema the DAO was generated
+// for. It reports every missing column, column of another type and difference in the
+// primary key or clustering order, so the DAO fails before its first query does.
+func (dao *%[1]v) VerifySchema(session *gocql.Session) error {
+  type column struct {
+    name, cqlType, kind string
+    position            int
+    order               string
+  }
+
+  keyspace, table := %[2]v, %[3]q%[5]v
+  if err := session.Query(`+"`"+`SELECT table_name FROM system_schema.tables WHERE keyspace_name = ? AND table_name = ?;`+"`"+`, keyspace, table).Scan(&table); err == gocql.ErrNotFound {
+    return fmt.Errorf("table %%v.%%v does not exist", keyspace, table)
+  } else if err != nil {
+    return err
+  }
+
+  actual := make(map[string]column)
+  iter := session.Query(`+"`"+`SELECT column_name, type, kind, position, clustering_order FROM system_schema.columns WHERE keyspace_name = ? AND table_name = ?;`+"`"+`, keyspace, table).Iter()
+  var c column
+  for iter.Scan(&c.name, &c.cqlType, &c.kind, &c.position, &c.order) {
+    c.cqlType = strings.ToLower(strings.Replace(c.cqlType, " ", "", -1))
+    actual[c.name] = c
+  }
+  if err := iter.Close(); err != nil {
+    return err
+  }
+
+  differences := make([]string, 0)
+  for _, expected := range []column{
+%[4]v
+  } {
+    c, ok := actual[expected.name]
+    delete(actual, expected.name)
+    if !ok {
+      differences = append(differences, fmt.Sprintf("column %%v is missing", expected.name))
+      continue
+    }
+
+    if c.cqlType != expected.cqlType {
+      differences = append(differences, fmt.Sprintf("column %%v is %%v, expected %%v", c.name, c.cqlType, expected.cqlType))
+    }
+    if c.kind != expected.kind || c.position != expected.position {
+      differences = append(differences, fmt.Sprintf("column %%v is %%v column %%v, expected %%v column %%v", c.name, c.kind, c.position, expected.kind, expected.position))
+    } else if c.order != expected.order {
+      differences = append(differences, fmt.Sprintf("column %%v clusters %%v, expected %%v", c.name, c.order, expected.order))
+    }
+  }
+  for _, c := range actual {
+    if c.kind == "partition_key" || c.kind == "clustering" {
+      differences = append(differences, fmt.Sprintf("column %%v is %%v column %%v, expected no such column", c.name, c.kind, c.position))
+    }
+  }
+
+  if len(differences) != 0 {
+    return fmt.Errorf("%%v.%%v does not match %[1]v:\n  %%v", keyspace, table, strings.Join(differences, "\n  "))
+  }
+  return nil
+}
+`, m.DAO, keyspace, schemaName(m.Table), strings.Join(columns, "\n"), normalize))
+}
+
+func (m _DAOModel) NamedQueries() template.HTML {
+	methods := make([]string, len(m.Queries))
+	for i, q := range m.Queries {
+		params := make([]string, 0, len(q.Params)+1)
+		args := make([]string, 0, len(q.Params)+1)
+		args = append(args, string(m.Statement(q.Name)))
+		for _, p := range q.Params {
+			params = append(params, fmt.Sprintf("%v %v", p.Name, p.GoType))
+			args = append(args, p.Name)
+		}
+		params = append(params, "_session ...*gocql.Session")
+		where, cql := strings.Join(strings.Fields(q.Where), " "), strings.Join(strings.Fields(q.CQL), " ")
+
+		switch q.Cardinality {
+		case "one":
+			methods[i] = fmt.Sprintf(`
+// %[1]v returns the first row matching: %[5]v
+func (dao *%[2]v) %[1]v(%[3]v) (*%[4]v, error) {
+  session, err, close := dao.session(_session...)
+  if err != nil {
+    return nil, err
+  } else if close {
+    defer session.Close()
+  }
+
+  if res, err := dao.list(session, %[6]v); err != nil && res == nil {
+    return nil, err
+  } else if len(res) == 0 {
+    return nil, err
+  } else {
+    return res[0], err
+  }
+}`, q.Name, m.DAO, strings.Join(params, ", "), m.ModelType(), where, strings.Join(args, ", "))
+		case "many":
+			methods[i] = fmt.Sprintf(`
+// %[1]v returns the rows matching: %[5]v
+func (dao *%[2]v) %[1]v(%[3]v) ([]*%[4]v, error) {
+  session, err, close := dao.session(_session...)
+  if err != nil {
+    return nil, err
+  } else if close {
+    defer session.Close()
+  }
+
+  return dao.list(session, %[6]v)
+}`, q.Name, m.DAO, strings.Join(params, ", "), m.ModelType(), where, strings.Join(args, ", "))
+		case "exec":
+			methods[i] = fmt.Sprintf(`
+// %[1]v runs: %[4]v
+func (dao *%[2]v) %[1]v(%[3]v) error {
+  session, err, close := dao.session(_session...)
+  if err != nil {
+    return err
+  } else if close {
+    defer session.Close()
+  }
+
+  return session.Query(%[5]v).Exec()
+}`, q.Name, m.DAO, strings.Join(params, ", "), cql, strings.Join(args, ", "))
+		}
+	}
+	return template.HTML(strings.Join(methods, "\n"))
+}
+
+func (m _DAOModel) SelectList() template.HTML {
+	keys := make([]string, len(m.partitioningKeys))
+	for i, k := range m.partitioningKeys {
+		keys[i] = k + "=?"
+	}
+	return template.HTML(strings.Join(keys, " AND "))
+}
+
+func (m _DAOModel) CreateResourceFromParameters() template.HTML {
+	resource := make([]string, 0, len(m.Columns))
+	for _, c := range m.Columns {
+		if c.SerializedType == "" {
+			resource = append(resource, fmt.Sprintf("          %v: %v", c.Field, c.Field))
+		} else if c.Container == "map" {
+			resource = append(resource, fmt.Sprintf("          %v: make(map[%v]%v)", c.Field, c.KeyGoType, c.SerializedType))
+		} else if c.Container != "blob" {
+			resource = append(resource, fmt.Sprintf("          %v: make([]%v, 0)", c.Field, c.SerializedType))
+		}
+	}
+	return template.HTML(strings.Join(resource, ",\n") + ",")
+}
+
+func (m _DAOModel) LenientSerialization() bool {
+	for _, c := range m.Columns {
+		if c.SerializationErrors == "lenient" {
+			return true
+		}
+	}
+	return false
+}
+
+func (m _DAOModel) SerializationErrorTypes() template.HTML {
+	if !m.IncludeSerializationErrors {
+		return template.HTML("")
+	}
+
+	return template.HTML(fmt.Sprintf(`
+// %[1]vSerializationError identifies the blob column element that could not be (de)serialized.
+type %[1]vSerializationError struct {
+  Column string
+  Key    string
+  Err    error
+}
+
+func (e *%[1]vSerializationError) Error() string {
+  if e.Key == "" {
+    return fmt.Sprintf("%[2]v.%%v: %%v", e.Column, e.Err)
+  }
+  return fmt.Sprintf("%[2]v.%%v[%%v]: %%v", e.Column, e.Key, e.Err)
+}
+
+func (e *%[1]vSerializationError) Unwrap() error {
+  return e.Err
+}
+
+// %[1]vSerializationReport collects the elements dropped by lenient columns.
+type %[1]vSerializationReport struct {
+  Failures []*%[1]vSerializationError
+}
+
+func (r *%[1]vSerializationReport) Error() string {
+  return fmt.Sprintf("%%v value(s) in %[2]v could not be (de)serialized, first: %%v", len(r.Failures), r.Failures[0])
+}
+
+func (r *%[1]vSerializationReport) add(failure *%[1]vSerializationError) *%[1]vSerializationReport {
+  if r == nil {
+    r = &%[1]vSerializationReport{}
+  }
+  r.Failures = append(r.Failures, failure)
+  return r
+}
+
+func (r *%[1]vSerializationReport) err() error {
+  if r == nil {
+    return nil
+  }
+  return r
+}
+`, m.Model, m.Table))
+}
+
+// serializationFailure renders what a column does with a value that failed to
+// (de)serialize while building the given target, honoring serializationErrors.
+func (m _DAOModel) serializationFailure(c *param, target, key, errVar, value string) string {
+	if key != `""` {
+		key = fmt.Sprintf("fmt.Sprint(%v)", key)
+	}
+
+	failure := fmt.Sprintf(`&%vSerializationError{Column: "%v", Key: %v, Err: %v}`, m.Model, c.Name, key, errVar)
+	switch c.SerializationErrors {
+	case "":
+		if target == "add" || target == "set" {
+			return fmt.Sprintf(`fmt.Println("Could not marshal value:", %v, %v)`, errVar, value)
+		}
+		return fmt.Sprintf(`fmt.Println("Could not unmarshal value", %v, %v)`, errVar, value)
+	case "lenient":
+		return fmt.Sprintf("report = report.add(%v)", failure)
+	}
+
+	switch target {
+	case "list":
+		return fmt.Sprintf(`iter.Close()
+        return nil, %v`, failure)
+	case "scan":
+		return fmt.Sprintf(`iter.Close()
+        return resume, false, %v`, failure)
+	case "cursor":
+		return fmt.Sprintf(`iter.Close()
+        return %v`, failure)
+	case "seq":
+		return fmt.Sprintf(`iter.Close()
+        yield(nil, %v)
+        return`, failure)
+	case "stream":
+		return fmt.Sprintf(`iter.Close()
+        %v{DTO: nil, ERR: %v}
+        return`, m.EmitStream(), failure)
+	case "set":
+		return fmt.Sprintf("return %v", failure)
+	default:
+		return fmt.Sprintf("return nil, %v", failure)
+	}
+}
+
+// decodeValue renders decoding data into a value that assign stores on the
+// resource. Columns that only log failures still store the zero value.
+func (m _DAOModel) decodeValue(c *param, target, key, data, assign string) string {
+	failure := m.serializationFailure(c, target, key, "derr", data)
+	if c.SerializationErrors == "" {
+		return fmt.Sprintf(`%v
+      if derr := %v; derr != nil {
+        %v
+      }
+      %v`, c.declareValue(), m.unmarshal(c, data), failure, assign)
+	}
+
+	return fmt.Sprintf(`%v
+      if derr := %v; derr != nil {
+        %v
+      } else {
+        %v
+      }`, c.declareValue(), m.unmarshal(c, data), failure, assign)
+}
+
+// encodeValue renders encoding value into the blob that assign stores for the insert.
+func (m _DAOModel) encodeValue(c *param, target, key, value, assign string) string {
+	return fmt.Sprintf(`if value, serr := %v; serr != nil {
+      %v
     } else {
-      fmt.Println("Could not marshal attribute:", k, serr, v)
-    }
-  }`, c.Name, c.Name, c.Name))
+      %v
+    }`, m.marshal(c, value), m.serializationFailure(c, target, key, "serr", value), assign)
+}
+
+// serializedGoType wraps the Go type of a single deserialized blob in the
+// column's collection, if any.
+func (c *param) serializedGoType(t string) string {
+	switch c.Container {
+	case "list", "set":
+		return "[]" + t
+	case "map":
+		return fmt.Sprintf("map[%v]%v", c.KeyGoType, t)
+	}
+	return t
+}
+
+// declareValue declares the variable a blob element is decoded into,
+// allocating it when deserializeTo is a pointer type.
+func (c *param) declareValue() string {
+	if strings.HasPrefix(c.SerializedType, "*") {
+		return fmt.Sprintf("value := new(%v)", c.SerializedType[1:])
+	}
+	return fmt.Sprintf("var value %v", c.SerializedType)
+}
+
+func (m _DAOModel) marshal(c *param, value string) string {
+	switch c.Serializer {
+	case "json":
+		return fmt.Sprintf("json.Marshal(%v)", value)
+	case "gob":
+		return fmt.Sprintf("dao.gobMarshal(%v)", value)
+	case "protobuf":
+		return fmt.Sprintf("proto.Marshal(%v)", value)
+	case "msgpack":
+		return fmt.Sprintf("msgpack.Marshal(%v)", value)
+	default:
+		return fmt.Sprintf("(%v).Marshal(%v)", c.Serializer, value)
+	}
+}
+
+func (m _DAOModel) unmarshal(c *param, data string) string {
+	target := "&value"
+	if strings.HasPrefix(c.SerializedType, "*") {
+		target = "value"
+	}
+
+	switch c.Serializer {
+	case "json":
+		return fmt.Sprintf("json.Unmarshal(%v, %v)", data, target)
+	case "gob":
+		return fmt.Sprintf("dao.gobUnmarshal(%v, %v)", data, target)
+	case "protobuf":
+		return fmt.Sprintf("proto.Unmarshal(%v, %v)", data, target)
+	case "msgpack":
+		return fmt.Sprintf("msgpack.Unmarshal(%v, %v)", data, target)
+	default:
+		return fmt.Sprintf("(%v).Unmarshal(%v, %v)", c.Serializer, data, target)
+	}
+}
+
+func (m _DAOModel) SerializerHelpers() template.HTML {
+	helpers := make([]string, 0)
+	if m.IncludeGob {
+		helpers = append(helpers, fmt.Sprintf(`
+func (dao *%[1]v) gobMarshal(v interface{}) ([]byte, error) {
+  var buff bytes.Buffer
+  if err := gob.NewEncoder(&buff).Encode(v); err != nil {
+    return nil, err
+  }
+  return buff.Bytes(), nil
+}
+
+func (dao *%[1]v) gobUnmarshal(data []byte, v interface{}) error {
+  return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}`, m.DAO))
+	}
+
+	if m.IncludeCustomSerializer && m.view == "" {
+		helpers = append(helpers, fmt.Sprintf(`
+// %[1]vBlobSerializer is implemented by the custom serializers of %[1]v's blob columns.
+type %[1]vBlobSerializer interface {
+  Marshal(v interface{}) ([]byte, error)
+  Unmarshal(data []byte, v interface{}) error
+}`, m.Model))
+		for _, c := range m.Columns {
+			switch c.Serializer {
+			case "", "json", "gob", "protobuf", "msgpack":
+			default:
+				helpers = append(helpers, fmt.Sprintf("var _ %vBlobSerializer = %v", m.Model, c.Serializer))
+			}
+		}
+	}
+
+	if len(helpers) == 0 {
+		return template.HTML("")
+	}
+	return template.HTML(strings.Join(helpers, "\n") + "\n")
+}
+
+func (m _DAOModel) DeserializeParameters(target string) template.HTML {
+	deser := make([]string, 0)
+	for _, c := range m.Columns {
+		if c.SerializedType == "" {
+			continue
+		}
+
+		switch c.Container {
+		case "blob":
+			deser = append(deser, fmt.Sprintf(`
+    if len(%v) != 0 {
+      %v
+    }`, c.Field, m.decodeValue(c, target, `""`, c.Field, fmt.Sprintf("resource.%v = value", c.Field))))
+		case "list", "set":
+			index := "_"
+			if c.SerializationErrors != "" {
+				index = "i"
+			}
+			deser = append(deser, fmt.Sprintf(`
+    for %v, v := range %v {
+      %v
+    }`, index, c.Field, m.decodeValue(c, target, "i", "v", fmt.Sprintf("resource.%v = append(resource.%v, value)", c.Field, c.Field))))
+		case "map":
+			deser = append(deser, fmt.Sprintf(`
+    for k, v := range %v {
+      %v
+    }`, c.Field, m.decodeValue(c, target, "k", "v", fmt.Sprintf("resource.%v[k] = value", c.Field))))
+		}
+	}
+
+	if len(deser) == 0 {
+		return template.HTML("")
+	}
+
+	return template.HTML(strings.Join(deser, "\n"))
+}
+
+func (m _DAOModel) SerializeParameters() template.HTML {
+	return m.serializeParameters("add")
+}
+
+// serializeParameters renders encoding the blob columns of r into locals named after their
+// fields, target picks how failures return.
+func (m _DAOModel) serializeParameters(target string) template.HTML {
+	ser := make([]string, 0)
+	for _, c := range m.Columns {
+		if c.SerializedType == "" {
+			continue
+		}
+
+		switch c.Container {
+		case "blob":
+			encode := m.encodeValue(c, target, `""`, "r."+c.Field, c.Field+" = value")
+			if strings.HasPrefix(c.SerializedType, "*") {
+				encode = fmt.Sprintf(`if r.%v != nil {
+    %v
+  }`, c.Field, encode)
 			}
+			ser = append(ser, fmt.Sprintf(`
+  var %v []byte
+  %v`, c.Field, encode))
+		case "list", "set":
+			index := "_"
+			if c.SerializationErrors != "" {
+				index = "i"
+			}
+			ser = append(ser, fmt.Sprintf(`
+  %v := make([][]byte, 0)
+  for %v, v := range r.%v {
+    %v
+  }`, c.Field, index, c.Field, m.encodeValue(c, target, "i", "v", fmt.Sprintf("%v = append(%v, value)", c.Field, c.Field))))
+		case "map":
+			ser = append(ser, fmt.Sprintf(`
+  %v := make(map[%v][]byte)
+  for k, v := range r.%v {
+    %v
+  }`, c.Field, c.KeyGoType, c.Field, m.encodeValue(c, target, "k", "v", fmt.Sprintf("%v[k] = value", c.Field))))
 		}
 	}
 
@@ -495,25 +2553,112 @@ func (m _DAOModel) BaseModelImports() template.HTML {
 	}
 }
 
+// localType strips the model package from a Go type used inside the model package.
+func (m _DAOModel) localType(goType string) string {
+	if m.ModelImport == "" {
+		return goType
+	}
+	return strings.Replace(goType, m.ModelImport+".", "", -1)
+}
+
+// usedTypes returns the user defined types the table's columns need, including the
+// types nested in them, ordered so every type follows the types its fields reference.
+func (m _DAOModel) usedTypes() []*typeDef {
+	used := make(map[*typeDef]bool)
+	var visit func(cqlType string)
+	visit = func(cqlType string) {
+		for _, name := range IDENTIFIER_REGEX.FindAllString(cqlType, -1) {
+			if udt := m.udt(name); udt != nil && !used[udt] {
+				used[udt] = true
+				for _, f := range udt.Fields {
+					visit(f.CqlType)
+				}
+			}
+		}
+	}
+	for _, c := range m.Columns {
+		visit(c.CqlType)
+	}
+
+	res, done, visiting := make([]*typeDef, 0, len(used)), make(map[*typeDef]bool), make(map[*typeDef]bool)
+	var add func(udt *typeDef)
+	add = func(udt *typeDef) {
+		if done[udt] {
+			return
+		} else if visiting[udt] {
+			log.Fatalf("Type %v references itself through its fields, cql types can not be recursive", udt.Name)
+		}
+		visiting[udt] = true
+		for _, f := range udt.Fields {
+			for _, name := range IDENTIFIER_REGEX.FindAllString(f.CqlType, -1) {
+				if dependency := m.udt(name); dependency != nil {
+					add(dependency)
+				}
+			}
+		}
+		done[udt] = true
+		res = append(res, udt)
+	}
+	for _, t := range m.types {
+		if used[t] {
+			add(t)
+		}
+	}
+	return res
+}
+
+func (m _DAOModel) TypeDefinition(udt *typeDef) string {
+	fields := make([]string, len(udt.Fields))
+	for i, f := range udt.Fields {
+		fields[i] = fmt.Sprintf("    %v %v", f.Name, f.CqlType)
+	}
+	return fmt.Sprintf("CREATE TYPE IF NOT EXISTS %v.%v (\n%v\n  );", m.keyspace(), udt.Name, strings.Join(fields, ",\n"))
+}
+
+func (m _DAOModel) CreateTypes() template.HTML {
+	types := make([]string, 0)
+	for _, udt := range m.usedTypes() {
+		types = append(types, fmt.Sprintf(`  if err := session.Query(%v).Exec(); err != nil {
+    return err
+  }
+`, m.Statement("Type"+udt.GoName)))
+	}
+	return template.HTML(strings.Join(types, "\n"))
+}
+
+func (m _DAOModel) UDTStructs() template.HTML {
+	structs := make([]string, len(m.generatedTypes))
+	for i, udt := range m.generatedTypes {
+		fields := make([]string, len(udt.Fields))
+		for j, f := range udt.Fields {
+			name := f.Field
+			if name == "" {
+				name = goName(f.Name)
+			}
+			goType, _, _ := m.goType(f.Name, f.CqlType)
+			fields[j] = fmt.Sprintf("%v %v `cql:\"%v\" json:\"%v\"`", name, m.localType(goType), schemaName(f.Name), strings.Trim(f.Name, `"`))
+		}
+		structs[i] = fmt.Sprintf("type %v struct {\n%v\n}", udt.GoName, strings.Join(fields, "\n"))
+	}
+	return template.HTML(strings.Join(structs, "\n\n"))
+}
+
 func (m _DAOModel) ModelFields() template.HTML {
 	fields := make([]string, len(m.Columns))
 	for i, c := range m.Columns {
-		r, n := utf8.DecodeRuneInString(c.Name)
-		jsonName := string(unicode.ToLower(r)) + c.Name[n:]
+		name := strings.Trim(c.Name, `"`)
+		r, n := utf8.DecodeRuneInString(name)
+		jsonName := string(unicode.ToLower(r)) + name[n:]
 
 		if c.SerializedType == "" {
-			fields[i] = fmt.Sprintf("%v %v `json:\"%v\"`", c.Name, c.GoType, jsonName)
+			fields[i] = fmt.Sprintf("%v %v `json:\"%v\"`", c.Field, m.localType(c.GoType), jsonName)
 		} else {
 			t := c.SerializedType
 			if strings.Contains(c.SerializedType, m.ModelImport+".") {
 				t = strings.Replace(c.SerializedType, m.ModelImport+".", "", 1)
 			}
 
-			if c.CqlType == "list<blob>" {
-				fields[i] = fmt.Sprintf("%v []%v `json:\"%v\"`", c.Name, t, jsonName)
-			} else if c.CqlType == "map<text,blob>" {
-				fields[i] = fmt.Sprintf("%v map[string]%v `json:\"%v\"`", c.Name, t, jsonName)
-			}
+			fields[i] = fmt.Sprintf("%v %v `json:\"%v\"`", c.Field, c.serializedGoType(t), jsonName)
 		}
 	}
 	return template.HTML(strings.Join(fields, "\n"))
@@ -530,8 +2675,6 @@ package {{.Package}}
 import (
 {{.BaseImports}}
 
-"github.com/gocql/gocql"
-
 {{.CleanAdditionalImports}}
 )
 
@@ -541,79 +2684,61 @@ type {{.Model}}Stream struct {
   DTO *{{.ModelType}}
   ERR error
 }
+{{.SerializationErrorTypes}}
 
-func (dao *{{.DAO}}) Init(session *gocql.Session) (error) {
-  return session.Query(` + "`" + `CREATE TABLE IF NOT EXISTS {{.Keyspace}}.{{.Table}} (
-{{.TableDefinition}},
-
-    PRIMARY KEY ({{.PartitioningKeys}}{{.ClusteringColumns}})
-  ){{.ClusteringOrder}};` + "`" + `).Exec()
-}
-
-func (dao *{{.DAO}}) Add(r *{{.ModelType}}, _session ...*gocql.Session) (*{{.ModelType}}, error) {
-  session, err, close := dao.session(_session...)
-  if err != nil {
-    return nil, err
-  } else if close {
-    defer session.Close()
-  }
-  {{.SerializeParameters}}
-  err = session.Query(` + "`" + `INSERT INTO {{.Keyspace}}.{{.Table}} ({{.InsertFields}})
-                      VALUES ({{.InsertValues}});` + "`" + `,
-                      {{.InsertResource}}).Exec()
-  if err != nil {
-    return nil, err
-  }
-  return r, nil
+{{if .HasKeyspaceOptions}}// InitKeyspace creates the keyspace of the DAO when it does not exist, it must run before Init.
+func (dao *{{.DAO}}) InitKeyspace(session *gocql.Session) error {
+  return session.Query({{.Statement "InitKeyspace"}}).Exec()
 }
 
-func (dao *{{.DAO}}) Get({{.SelectSingleKeys}} interface{}, _session ...*gocql.Session) (*{{.ModelType}}, error) {
-  session, err, close := dao.session(_session...)
-  if err != nil {
-    return nil, err
-  } else if close {
-    defer session.Close()
-  }
-
-  if res, err := dao.list(session, ` + "`" + `SELECT {{.InsertFields}} FROM {{.Keyspace}}.{{.Table}} WHERE {{.SelectSingle}};` + "`" + `, {{.SelectSingleKeys}}); err != nil {
-    return nil, err
-  } else if len(res) != 1 {
-    return nil, nil
-  } else {
-    return res[0], nil
+{{end}}func (dao *{{.DAO}}) Init(session *gocql.Session) (error) {
+{{.CreateTypes}}
+{{if .HasDependents}}  if err := session.Query({{.Statement "Init"}}).Exec(); err != nil {
+    return err
   }
+{{.CreateDependents}}
+  return nil{{else}}  return session.Query({{.Statement "Init"}}).Exec(){{end}}
 }
 
-func (dao *{{.DAO}}) List({{.SelectListKeys}} interface{}, _session ...*gocql.Session) ([]*{{.ModelType}}, error) {
+{{if .CounterTable}}{{.CounterMethods}}{{else}}func (dao *{{.DAO}}) Add(r *{{.ModelType}}, _session ...*gocql.Session) (*{{.ModelType}}, error) {
   session, err, close := dao.session(_session...)
   if err != nil {
     return nil, err
   } else if close {
     defer session.Close()
   }
-
-  return dao.list(session, ` + "`" + `SELECT {{.InsertFields}} FROM {{.Keyspace}}.{{.Table}} WHERE {{.SelectList}};` + "`" + `, {{.SelectListKeys}})
-}
-
-func (dao *{{.DAO}}) ListAll(_session ...*gocql.Session) ([]*{{.ModelType}}, error) {
-  session, err, close := dao.session(_session...)
+  {{if .LenientSerialization}}
+  var report *{{.Model}}SerializationReport{{end}}
+  {{.SerializeParameters}}
+  err = session.Query({{.Statement "Add"}},
+                      {{.InsertResource}}).Exec()
   if err != nil {
     return nil, err
-  } else if close {
-    defer session.Close()
   }
-
-  return dao.list(session, ` + "`" + `SELECT {{.InsertFields}} FROM {{.Keyspace}}.{{.Table}};` + "`" + `)
+  return r, {{if .LenientSerialization}}report.err(){{else}}nil{{end}}
 }
-
+{{end}}
+{{template "Get" .}}
+{{.GetMany}}
+{{template "List" .}}
+// Stream sends the partition's rows on the returned channel, ending with an element
+// holding the error if the query fails.
+//
+// Deprecated: the channel must be drained or the goroutine and its session leak, use StreamContext.
 func (dao *{{.DAO}}) Stream({{.SelectListKeys}} interface{}) chan *{{.Model}}Stream {
-  return dao.stream(` + "`" + `SELECT {{.InsertFields}} FROM {{.Keyspace}}.{{.Table}} WHERE {{.SelectList}};` + "`" + `, {{.SelectListKeys}})
+  return dao.stream({{.Statement "List"}}, {{.SelectListKeys}})
 }
 
+// StreamAll sends every row of the table on the returned channel, ending with an element
+// holding the error if the query fails.
+//
+// Deprecated: the channel must be drained or the goroutine and its session leak, use StreamAllContext.
 func (dao *{{.DAO}}) StreamAll() chan *{{.Model}}Stream {
-  return dao.stream(` + "`" + `SELECT {{.InsertFields}} FROM {{.Keyspace}}.{{.Table}};` + "`" + `)
+  return dao.stream({{.Statement "ListAll"}})
 }
 
+{{template "Iterate" .}}
+{{.TokenScan}}
 func (dao *{{.DAO}}) Delete(r *{{.ModelType}}, _session ...*gocql.Session) error {
   session, err, close := dao.session(_session...)
   if err != nil {
@@ -622,24 +2747,19 @@ func (dao *{{.DAO}}) Delete(r *{{.ModelType}}, _session ...*gocql.Session) error
     defer session.Close()
   }
 
-  return dao.delete(session, ` + "`" + `DELETE FROM {{.Keyspace}}.{{.Table}} WHERE {{.SelectSingle}};` + "`" + `, {{.DeleteKeys}})
+  return dao.delete(session, {{.Statement "Delete"}}, {{.DeleteKeys}})
 }
 
-func (dao *{{.DAO}}) DropTable(session *gocql.Session) error {
-  return session.Query(` + "`" + `DROP TABLE IF EXISTS {{.Keyspace}}.{{.Table}};` + "`" + `).Exec()
-}
+{{.StaticMethods}}
+{{.IndexQueries}}
+{{.NamedQueries}}
 
-func (dao *{{.DAO}}) session(_session ...*gocql.Session) (*gocql.Session, error, bool) {
-  if _session == nil || len(_session) != 1 || _session[0] == nil {
-    if session, err := dao.createSession(); err != nil {
-      return nil, err, false
-    } else {
-      return session, nil, true
-    }
-  }
-  return _session[0], nil, false
+func (dao *{{.DAO}}) DropTable(session *gocql.Session) error {
+{{.DropViews}}  return session.Query({{.Statement "DropTable"}}).Exec()
 }
+{{.VerifySchema}}
 
+{{template "session" .}}
 func (dao *{{.DAO}}) stream(cql string, params ...interface{}) chan *{{.Model}}Stream {
   stream := make(chan *{{.Model}}Stream, dao.capacity())
 
@@ -654,7 +2774,7 @@ func (dao *{{.DAO}}) stream(cql string, params ...interface{}) chan *{{.Model}}S
       session.SetPageSize(dao.pageSize())
 
       var (
-        {{range .Columns}}{{.Name}} {{.GoType}}
+        {{range .Columns}}{{.Field}} {{.GoType}}
         {{end}})
 
       iter := session.Query(cql, params...).Iter()
@@ -662,9 +2782,11 @@ func (dao *{{.DAO}}) stream(cql string, params ...interface{}) chan *{{.Model}}S
         resource := &{{.ModelType}}{
 {{.CreateResourceFromParameters}}
         }
-        {{.DeserializeParameters}}
+        {{if .LenientSerialization}}
+        var report *{{.Model}}SerializationReport{{end}}
+        {{.DeserializeParameters "stream"}}
 
-        {{.EmitStream}}{DTO: resource, ERR: nil}
+        {{.EmitStream}}{DTO: resource, ERR: {{if .LenientSerialization}}report.err(){{else}}nil{{end}}}
       }
 
       if err := iter.Close(); err != nil {
@@ -677,19 +2799,134 @@ func (dao *{{.DAO}}) stream(cql string, params ...interface{}) chan *{{.Model}}S
   return stream
 }
 
-func (dao *{{.DAO}}) list(session *gocql.Session, cql string, params ...interface{}) ([]*{{.ModelType}}, error) {
+{{template "list" .}}
+func (dao *{{.DAO}}) delete(session *gocql.Session, cql string, params ...interface{}) error {
+  return session.Query(cql, params...).Exec()
+}
+{{.StreamHandle}}
+{{.RuntimeStatements}}
+{{if .Iterators}}{{template "seq" .}}{{end}}
+{{.SerializerHelpers}}
+`
+
+const _ViewDAOTemplate = `// Code generated by "gocql-gen"; DO NOT EDIT THIS FILE
+/*
+ *
+ * Model that generated this code: {{.RawJSON}}
+ *
+ */
+package {{.Package}}
+
+import (
+{{.BaseImports}}
+
+{{.CleanAdditionalImports}}
+)
+
+{{.InjectBoilerPlate}}
+
+{{template "Get" .}}
+{{template "List" .}}
+{{template "Iterate" .}}
+{{.TokenScan}}
+{{template "session" .}}
+{{template "list" .}}
+{{.StreamProducer}}
+{{.RuntimeStatements}}
+{{if .Iterators}}{{template "seq" .}}{{end}}
+{{.SerializerHelpers}}
+`
+
+// _ReadTemplate holds the methods that read rows, shared by the DAOs of tables and views.
+const _ReadTemplate = `{{define "Get"}}func (dao *{{.DAO}}) Get({{.SelectSingleKeys}} interface{}, _session ...*gocql.Session) (*{{.ModelType}}, error) {
+  session, err, close := dao.session(_session...)
+  if err != nil {
+    return nil, err
+  } else if close {
+    defer session.Close()
+  }
+
+  if res, err := dao.list(session, {{.Statement "Get"}}, {{.SelectSingleKeys}}); err != nil && res == nil {
+    return nil, err
+  } else if len(res) != 1 {
+    return nil, err
+  } else {
+    return res[0], err
+  }
+}
+{{end}}
+{{define "List"}}func (dao *{{.DAO}}) List({{.SelectListKeys}} interface{}, _session ...*gocql.Session) ([]*{{.ModelType}}, error) {
+  session, err, close := dao.session(_session...)
+  if err != nil {
+    return nil, err
+  } else if close {
+    defer session.Close()
+  }
+
+  return dao.list(session, {{.Statement "List"}}, {{.SelectListKeys}})
+}
+
+func (dao *{{.DAO}}) ListAll(_session ...*gocql.Session) ([]*{{.ModelType}}, error) {
+  session, err, close := dao.session(_session...)
+  if err != nil {
+    return nil, err
+  } else if close {
+    defer session.Close()
+  }
+
+  return dao.list(session, {{.Statement "ListAll"}})
+}
+{{end}}
+{{define "Iterate"}}// StreamContext streams the partition's rows until they are exhausted, ctx is done or the
+// handle is closed. Rows are only read from Cassandra as fast as they are consumed.
+func (dao *{{.DAO}}) StreamContext(ctx context.Context, {{.SelectListKeys}} interface{}) *{{.Model}}StreamHandle {
+  return dao.streamContext(ctx, {{.Statement "List"}}, {{.SelectListKeys}})
+}
+
+// StreamAllContext streams every row of the table until they are exhausted, ctx is done or
+// the handle is closed.
+func (dao *{{.DAO}}) StreamAllContext(ctx context.Context) *{{.Model}}StreamHandle {
+  return dao.streamContext(ctx, {{.Statement "ListAll"}})
+}
+
+{{if .Iterators}}
+// All iterates over the partition's rows, reading pages from Cassandra as the loop
+// advances. Breaking out of the loop stops the query and releases its session.
+func (dao *{{.DAO}}) All(ctx context.Context, {{.SelectListKeys}} interface{}, _session ...*gocql.Session) iter.Seq2[*{{.ModelType}}, error] {
+  return dao.seq(ctx, _session, {{.Statement "List"}}, {{.SelectListKeys}})
+}
+
+// AllRows iterates over every row of the table, reading pages from Cassandra as the
+// loop advances.
+func (dao *{{.DAO}}) AllRows(ctx context.Context, _session ...*gocql.Session) iter.Seq2[*{{.ModelType}}, error] {
+  return dao.seq(ctx, _session, {{.Statement "ListAll"}})
+}
+{{end}}{{end}}
+{{define "session"}}func (dao *{{.DAO}}) session(_session ...*gocql.Session) (*gocql.Session, error, bool) {
+  if _session == nil || len(_session) != 1 || _session[0] == nil {
+    if session, err := dao.createSession(); err != nil {
+      return nil, err, false
+    } else {
+      return session, nil, true
+    }
+  }
+  return _session[0], nil, false
+}
+{{end}}
+{{define "list"}}func (dao *{{.DAO}}) list(session *gocql.Session, cql string, params ...interface{}) ([]*{{.ModelType}}, error) {
   var (
-    {{range .Columns}}{{.Name}} {{.GoType}}
+    {{range .Columns}}{{.Field}} {{.GoType}}
     {{end}})
 
-  session.SetPageSize(dao.pageSize())
-  iter := session.Query(cql, params...).Iter()
+  iter := session.Query(cql, params...).PageSize(dao.pageSize()).Iter()
   results := make([]*{{.ModelType}}, 0, dao.capacity())
+  {{if .LenientSerialization}}
+  var report *{{.Model}}SerializationReport{{end}}
   for iter.Scan({{.GetScanParameters}}) {
     resource := &{{.ModelType}}{
 {{.CreateResourceFromParameters}}
     }
-    {{.DeserializeParameters}}
+    {{.DeserializeParameters "list"}}
 
     results = append(results, resource)
   }
@@ -699,14 +2936,44 @@ func (dao *{{.DAO}}) list(session *gocql.Session, cql string, params ...interfac
     return nil, err
   }
 
-  return results, nil
+  return results, {{if .LenientSerialization}}report.err(){{else}}nil{{end}}
 }
+{{end}}
+{{define "seq"}}func (dao *{{.DAO}}) seq(ctx context.Context, _session []*gocql.Session, cql string, params ...interface{}) iter.Seq2[*{{.ModelType}}, error] {
+  return func(yield func(*{{.ModelType}}, error) bool) {
+    session, err, close := dao.session(_session...)
+    if err != nil {
+      yield(nil, err)
+      return
+    } else if close {
+      defer session.Close()
+    }
 
-func (dao *{{.DAO}}) delete(session *gocql.Session, cql string, params ...interface{}) error {
-  return session.Query(cql, params...).Exec()
-}
+    var (
+      {{range .Columns}}{{.Field}} {{.GoType}}
+      {{end}})
 
-`
+    iter := session.Query(cql, params...).WithContext(ctx).PageSize(dao.pageSize()).Iter()
+    for iter.Scan({{.GetScanParameters}}) {
+      resource := &{{.ModelType}}{
+{{.CreateResourceFromParameters}}
+      }
+      {{if .LenientSerialization}}
+      var report *{{.Model}}SerializationReport{{end}}
+      {{.DeserializeParameters "seq"}}
+
+      if !yield(resource, {{if .LenientSerialization}}report.err(){{else}}nil{{end}}) {
+        iter.Close()
+        return
+      }
+    }
+
+    if err := iter.Close(); err != nil {
+      yield(nil, err)
+    }
+  }
+}
+{{end}}`
 
 const _DTOTemplate = `// Code generated by "gocql-gen"; DO NOT EDIT THIS FILE
 /*
@@ -723,3 +2990,11 @@ type {{.Model}} struct {
 }
 
 `
+
+const _UDTTemplate = `// Code generated by "gocql-gen"; DO NOT EDIT THIS FILE
+package {{.Package}}
+
+{{.BaseModelImports}}
+
+{{.UDTStructs}}
+`
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGoName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"user_id":    "UserID",
+		"api_url":    "APIURL",
+		"created":    "Created",
+		"UserID":     "UserID",
+		"__weird__":  "Weird",
+		`"type"`:     "Type",
+		`"Order"`:    "Order",
+		`"zip_code"`: "ZipCode",
+	} {
+		if actual := goName(name); actual != expected {
+			t.Errorf("goName(%q) is %q, expected %q", name, actual, expected)
+		}
+	}
+}
+
+func TestNamedQuery(t *testing.T) {
+	m := &_DAOModel{Keyspace: "accounts", Table: "users", Columns: []*param{
+		{Name: "user_id", Field: "UserID", CqlType: "uuid"},
+		{Name: `"type"`, Field: "Type", CqlType: "text"},
+		{Name: "session", Field: "Session", CqlType: "text"},
+	}}
+
+	query := m.namedQuery(&queryDef{
+		Name:        "ByType",
+		Cardinality: "many",
+		Where:       `WHERE user_id = ? AND "type" = ? AND session = ? LIMIT ?`,
+		Params:      []*queryParamDef{{Name: "user_id"}, {Name: `"type"`}, {Name: "session"}, {Name: "err", CqlType: "int"}},
+	})
+
+	if query.CQL != `SELECT user_id, "type", session FROM accounts.users WHERE user_id = ? AND "type" = ? AND session = ? LIMIT ?;` {
+		t.Errorf("ByType has cql %v", query.CQL)
+	}
+
+	expected := []param{
+		{Name: "userID", GoType: "*gocql.UUID", CqlType: "uuid"},
+		{Name: "typeValue", GoType: "string", CqlType: "text"},
+		{Name: "sessionValue", GoType: "string", CqlType: "text"},
+		{Name: "errValue", GoType: "int", CqlType: "int"},
+	}
+	params := make([]param, len(query.Params))
+	for i, p := range query.Params {
+		params[i] = *p
+	}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("ByType has params\n  %+v\nexpected\n  %+v", params, expected)
+	}
+}
+
+func TestUsedTypes(t *testing.T) {
+	address := &typeDef{Name: "address", Fields: []*columnDef{{Name: "street", CqlType: "text"}, {Name: "geo", CqlType: "frozen<point>"}}}
+	contact := &typeDef{Name: "contact", Fields: []*columnDef{{Name: "homes", CqlType: "list<frozen<address>>"}}}
+	point := &typeDef{Name: "point", Fields: []*columnDef{{Name: "lat", CqlType: "double"}}}
+	unused := &typeDef{Name: "unused", Fields: []*columnDef{{Name: "id", CqlType: "uuid"}}}
+	m := _DAOModel{types: []*typeDef{contact, unused, address, point}, Columns: []*param{{Name: "contact", CqlType: "frozen<contact>"}}}
+
+	names := make([]string, 0)
+	for _, udt := range m.usedTypes() {
+		names = append(names, udt.Name)
+	}
+	if expected := []string{"point", "address", "contact"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("used types are %v, expected %v", names, expected)
+	}
+}
+
+func TestSourceFile(t *testing.T) {
+	if actual := sourceFile("internal/Accounts/DAO", "UserEvents-dao_gen.go"); actual != "internal/Accounts/DAO/userevents-dao_gen.go" {
+		t.Errorf("sourceFile lower cased the directories into %v", actual)
+	}
+}
+
+func TestBaseImports(t *testing.T) {
+	dir := t.TempDir()
+	boilerPlate := path.Join(dir, "boilerplate.tmpl")
+	if err := os.WriteFile(boilerPlate, []byte("import (\n  \"sync\"\n  \"out/model\"\n)\n\nvar _ sync.Mutex\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := _DAOModel{BoilerPlate: boilerPlate, AdditionalImports: []string{`"time"`, `"out/model"`, `"out/util"`, ` "out/util"`, `gocql "github.com/gocql/gocql"`}}
+	base := strings.Fields(string(m.BaseImports()))
+	if expected := []string{`"context"`, `"fmt"`, `"math"`, `"errors"`, `"strings"`}; !reflect.DeepEqual(base, expected) {
+		t.Errorf("base imports are %v, expected %v", base, expected)
+	}
+	if additional := strings.Fields(string(m.CleanAdditionalImports())); !reflect.DeepEqual(additional, []string{`"time"`, `"out/util"`, `"github.com/gocql/gocql"`}) {
+		t.Errorf("additional imports are %v", additional)
+	}
+
+	m = _DAOModel{iterators: true}
+	if !strings.Contains(string(m.BaseImports()), `"iter"`) {
+		t.Errorf("iterators do not import iter")
+	}
+}
+
+func TestSupportsIterators(t *testing.T) {
+	dir := t.TempDir()
+	for version, expected := range map[string]bool{"1.21": false, "1.22.5": false, "1.23": true, "1.24.0": true} {
+		if err := os.WriteFile(path.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo "+version+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if actual := supportsIterators(dir); actual != expected {
+			t.Errorf("a go %v module supports iterators %v, expected %v", version, actual, expected)
+		}
+	}
+}
+
+func TestGetManyNormalizesKeys(t *testing.T) {
+	m := _DAOModel{Keyspace: "ks", Model: "Visit", Table: "visits", DAO: "VisitDAO", keys: []string{"day", "at"}, partitioningKeys: []string{"day"}, clusteringKeys: []string{"at"}, Columns: []*param{
+		{Name: "day", Field: "Day", GoType: "*time.Time", CqlType: "date"},
+		{Name: "at", Field: "At", GoType: "*time.Time", CqlType: "timestamp"},
+	}}
+
+	source := string(m.GetMany())
+	for _, expected := range []string{
+		"k.Day = k.Day.UTC().Truncate(24 * time.Hour)",
+		"k.At = time.UnixMilli(k.At.UnixMilli()).UTC()",
+		"w != options.Workers && w != len(order)",
+	} {
+		if !strings.Contains(source, expected) {
+			t.Errorf("GetMany does not contain %v", expected)
+		}
+	}
+}
+
+func TestStaticMethods(t *testing.T) {
+	m := _DAOModel{Keyspace: "ks", Model: "Event", Table: "events", DAO: "EventDAO", keys: []string{"stream", "seq"}, partitioningKeys: []string{"stream"}, clusteringKeys: []string{"seq"}, statics: []string{"tags"}, Columns: []*param{
+		{Name: "stream", Field: "Stream", GoType: "string", CqlType: "text"},
+		{Name: "seq", Field: "Seq", GoType: "int", CqlType: "int"},
+		{Name: "tags", Field: "Tags", GoType: "[][]byte", CqlType: "list<blob>", Container: "list", SerializedType: "Tag", Serializer: "json", SerializationErrors: "strict"},
+		{Name: "body", Field: "Body", GoType: "string", CqlType: "text"},
+	}}
+
+	if statement := m.Statement("GetStatic"); !strings.Contains(string(statement), "SELECT stream, tags FROM ks.events WHERE stream=? LIMIT 1;") {
+		t.Errorf("GetStatic selects %v", statement)
+	}
+
+	source := string(m.StaticMethods())
+	for _, expected := range []string{
+		"type EventStatic struct",
+		"Tags []Tag",
+		"(*EventStatic, error)",
+		"Tags = append(Tags, value)",
+	} {
+		if !strings.Contains(source, expected) {
+			t.Errorf("StaticMethods does not contain %v", expected)
+		}
+	}
+	if strings.Contains(source, "Body") {
+		t.Error("StaticMethods reads the regular columns")
+	}
+}
+
+func TestVerifySchemaKeyspace(t *testing.T) {
+	m := _DAOModel{Keyspace: "Shop", Model: "Cart", Table: "Carts", DAO: "CartDAO", Columns: []*param{
+		{Name: "UserID", Field: "UserID", GoType: "string", CqlType: "text"},
+	}}
+	if source := string(m.VerifySchema()); !strings.Contains(source, `keyspace, table := "shop", "carts"`) || !strings.Contains(source, `{"userid", "text"`) {
+		t.Errorf("VerifySchema does not lower case unquoted names:\n%v", source)
+	}
+
+	m.RuntimeKeyspace = true
+	if source := string(m.VerifySchema()); !strings.Contains(source, "keyspace = strings.ToLower(keyspace)") {
+		t.Errorf("VerifySchema does not lower case the runtime keyspace:\n%v", source)
+	}
+}
+
+// GENERATED_FIXTURE uses every DAO template, a clustered table with a view and named queries,
+// a table with serialized statics and a counter table with a static counter.
+var GENERATED_FIXTURE = map[string]string{
+	"go.mod":         "module fixture\n\ngo 1.23\n\nrequire github.com/gocql/gocql v1.7.0\n",
+	"model/model.go": "package model\n",
+	"dao.go": `package dao
+
+import "github.com/gocql/gocql"
+
+type base struct{ cluster *gocql.ClusterConfig }
+
+func (b *base) createSession() (*gocql.Session, error) { return b.cluster.CreateSession() }
+func (b *base) capacity() int                          { return 10 }
+func (b *base) pageSize() int                          { return 100 }
+
+type OrderDAO struct{ base }
+type OrderByNameDAO struct{ base }
+type EventDAO struct{ base }
+type PageViewDAO struct{ base }
+`,
+	"persist-config.json": `{
+  "keyspace": "shop",
+  "package": "dao",
+  "modelPackage": "model",
+  "imports": ["\"fixture/model\""],
+  "modelGeneration": {"package": "model", "location": "model"},
+  "keyspaceOptions": {"replication": {"class": "SimpleStrategy", "replicationFactor": 1}},
+  "tables": [
+    {
+      "modelName": "Order", "tableName": "orders", "dao": "OrderDAO", "generatedName": "order",
+      "columns": [
+        {"name": "user_id", "field": "UserID", "type": "uuid", "key": "partition"},
+        {"name": "created", "field": "Created", "type": "timestamp", "key": "cluster-desc"},
+        {"name": "day", "field": "Day", "type": "date"},
+        {"name": "name", "field": "Name", "type": "text", "index": "sai"},
+        {"name": "items", "field": "Items", "type": "list<blob>", "deserializeTo": "string", "serializer": "json", "serializationErrors": "strict"}
+      ],
+      "queries": [
+        {"name": "ListSince", "cardinality": "many", "where": "WHERE user_id = ? AND created > ? LIMIT ?", "params": [{"name": "user_id"}, {"name": "since", "type": "timestamp"}, {"name": "limit", "type": "int"}]},
+        {"name": "Latest", "cardinality": "one", "where": "WHERE user_id = ? LIMIT 1", "params": [{"name": "user_id"}]}
+      ],
+      "views": [
+        {"name": "orders_by_name", "dao": "OrderByNameDAO", "keys": [{"name": "name", "key": "partition"}, {"name": "user_id", "key": "cluster"}, {"name": "created", "key": "cluster-desc"}]}
+      ],
+      "tableOptions": {"gcGraceSeconds": 3600, "compaction": {"class": "LCS"}}
+    },
+    {
+      "modelName": "Event", "tableName": "events", "dao": "EventDAO", "generatedName": "event",
+      "columns": [
+        {"name": "stream", "field": "Stream", "type": "text", "key": "partition"},
+        {"name": "seq", "field": "Seq", "type": "int", "key": "cluster"},
+        {"name": "owner", "field": "Owner", "type": "text", "key": "static"},
+        {"name": "labels", "field": "Labels", "type": "map<text,blob>", "key": "static", "deserializeTo": "string", "serializer": "json", "serializationErrors": "lenient"},
+        {"name": "body", "field": "Body", "type": "text"}
+      ]
+    },
+    {
+      "modelName": "PageView", "tableName": "page_views", "dao": "PageViewDAO", "generatedName": "page_view",
+      "columns": [
+        {"name": "page", "field": "Page", "type": "text", "key": "partition"},
+        {"name": "day", "field": "Day", "type": "date", "key": "cluster-desc"},
+        {"name": "views", "field": "Views", "type": "counter"},
+        {"name": "total", "field": "Total", "type": "counter", "key": "static"}
+      ]
+    }
+  ]
+}
+`,
+}
+
+// TestGeneratedCodeVets generates GENERATED_FIXTURE and vets the DAOs, models and migration
+// runner it writes. It is skipped when the go tool or the gocql module are unavailable.
+func TestGeneratedCodeVets(t *testing.T) {
+	if dir := os.Getenv("GOCQL_GEN_FIXTURE"); dir != "" {
+		// the generator runs in a child process, it exits on the first error
+		if err := os.Chdir(dir); err != nil {
+			t.Fatal(err)
+		}
+		os.Args = append([]string{"gocql-gen"}, strings.Fields(os.Getenv("GOCQL_GEN_ARGS"))...)
+		main()
+		return
+	}
+
+	if testing.Short() {
+		t.Skip("generating and vetting a module is slow")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go tool is not installed")
+	}
+
+	dir := t.TempDir()
+	for file, content := range GENERATED_FIXTURE {
+		if err := os.MkdirAll(path.Dir(path.Join(dir, file)), 0755); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(path.Join(dir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first migration makes the generator write the migration runner
+	for _, args := range []string{"migrate init", ""} {
+		generate := exec.Command(os.Args[0], "-test.run=^TestGeneratedCodeVets$")
+		generate.Env = append(os.Environ(), "GOCQL_GEN_FIXTURE="+dir, "GOCQL_GEN_ARGS="+args)
+		if out, err := generate.CombinedOutput(); err != nil {
+			t.Fatalf("gocql-gen %v failed: %v\n%s", args, err, out)
+		}
+	}
+
+	for file, methods := range map[string][]string{
+		"order-dao_gen.go":          {"ScanAll", "GetMany", "ListSince", "Latest"},
+		"orders_by_name-dao_gen.go": {"ScanAll"},
+		"event-dao_gen.go":          {"GetStatic", "SetStatic"},
+		"page_view-dao_gen.go":      {"IncrementViews", "CounterBatch"},
+		"migrate_gen.go":            {"Migrate"},
+		"model/order-dto_gen.go":    {},
+	} {
+		source, err := os.ReadFile(path.Join(dir, file))
+		if err != nil {
+			t.Errorf("%v was not generated: %v", file, err)
+		}
+		for _, method := range methods {
+			if !strings.Contains(string(source), " "+method+"(") {
+				t.Errorf("%v does not declare %v", file, method)
+			}
+		}
+	}
+
+	resolve := exec.Command(goTool, "list", "-mod=mod", "-deps", "./...")
+	resolve.Dir = dir
+	if out, err := resolve.CombinedOutput(); err != nil {
+		t.Skipf("could not resolve the dependencies of the generated code: %v\n%s", err, out)
+	}
+
+	vet := exec.Command(goTool, "vet", "-mod=mod", "./...")
+	vet.Dir = dir
+	if out, err := vet.CombinedOutput(); err != nil {
+		t.Errorf("the generated code does not vet: %v\n%s", err, out)
+	}
+}
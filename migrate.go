@@ -0,0 +1,697 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SNAPSHOT_FILE records the schema of the last migration, it is written next to the
+// generated DAOs and compared against the config by migrate.
+const SNAPSHOT_FILE = "gocql-schema.json"
+
+// MIGRATIONS_DIR holds the numbered .cql migrations written by migrate.
+const MIGRATIONS_DIR = "migrations"
+
+// MIGRATION_NAME_REGEX matches the runs of characters a migration file name can not hold.
+var MIGRATION_NAME_REGEX = regexp.MustCompile(`[^a-z0-9_]+`)
+
+type schemaSnapshot struct {
+	Types  []*typeSnapshot  `json:"types"`
+	Tables []*tableSnapshot `json:"tables"`
+}
+
+type typeSnapshot struct {
+	Keyspace string           `json:"keyspace"`
+	Name     string           `json:"name"`
+	Fields   []*fieldSnapshot `json:"fields"`
+	CQL      string           `json:"cql"`
+}
+
+type fieldSnapshot struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Static bool   `json:"static,omitempty"`
+}
+
+type tableSnapshot struct {
+	Keyspace      string               `json:"keyspace"`
+	Name          string               `json:"name"`
+	Columns       []*fieldSnapshot     `json:"columns"`
+	PartitionKeys []string             `json:"partitionKeys"`
+	Clustering    []string             `json:"clustering,omitempty"`
+	Options       []string             `json:"options,omitempty"`
+	Indexes       []*statementSnapshot `json:"indexes,omitempty"`
+	Views         []*statementSnapshot `json:"views,omitempty"`
+	CQL           string               `json:"cql"`
+}
+
+type statementSnapshot struct {
+	Name string `json:"name"`
+	CQL  string `json:"cql"`
+}
+
+// newSnapshot records the schema of the models. Statements of DAOs picking their keyspace
+// at runtime keep the {keyspace} placeholder Migrate replaces, unless literal records them
+// for the keyspace of the config.
+func newSnapshot(models []*_DAOModel, literal bool) *schemaSnapshot {
+	snapshot := &schemaSnapshot{Types: make([]*typeSnapshot, 0), Tables: make([]*tableSnapshot, 0)}
+	seen := make(map[string]bool)
+	for _, m := range models {
+		keyspace := m.keyspace()
+		if literal {
+			keyspace = m.Keyspace
+		}
+
+		statements := make(map[string]string)
+		for _, s := range m.statements() {
+			statements[s.Name] = strings.Replace(s.CQL, "{keyspace}", keyspace, -1)
+		}
+
+		for _, udt := range m.usedTypes() {
+			if key := keyspace + "." + udt.Name; !seen[key] {
+				seen[key] = true
+				t := &typeSnapshot{Keyspace: keyspace, Name: udt.Name, CQL: statements["Type"+udt.GoName]}
+				for _, f := range udt.Fields {
+					t.Fields = append(t.Fields, &fieldSnapshot{Name: f.Name, Type: f.CqlType})
+				}
+				snapshot.Types = append(snapshot.Types, t)
+			}
+		}
+
+		table := &tableSnapshot{Keyspace: keyspace, Name: m.Table, PartitionKeys: m.partitioningKeys, Options: m.tableOptions.clauses(), CQL: statements["Init"]}
+		for _, c := range m.Columns {
+			table.Columns = append(table.Columns, &fieldSnapshot{c.Name, c.CqlType, contains(m.statics, c.Name)})
+		}
+		for _, k := range m.clusteringKeys {
+			if contains(m.clusteringOrder, k+" DESC") {
+				table.Clustering = append(table.Clustering, k+" DESC")
+			} else {
+				table.Clustering = append(table.Clustering, k+" ASC")
+			}
+		}
+		for _, c := range m.Indexes() {
+			table.Indexes = append(table.Indexes, &statementSnapshot{c.IndexName, statements["Index"+c.Field]})
+		}
+		for _, v := range m.views {
+			table.Views = append(table.Views, &statementSnapshot{v.Table, statements["View"+v.view]})
+		}
+		snapshot.Tables = append(snapshot.Tables, table)
+	}
+	return snapshot
+}
+
+// readSnapshot reads the snapshot in file, nil when none was written yet.
+func readSnapshot(file string) (*schemaSnapshot, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read schema snapshot %v: %v", file, err)
+	}
+
+	var snapshot schemaSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("could not parse schema snapshot %v: %v", file, err)
+	}
+	return &snapshot, nil
+}
+
+func writeSnapshot(file string, snapshot *schemaSnapshot) {
+	var buff bytes.Buffer
+	encoder := json.NewEncoder(&buff)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(snapshot); err != nil {
+		log.Fatalf("Could not encode schema snapshot: %v", err)
+	} else if err := os.WriteFile(file, buff.Bytes(), 0644); err != nil {
+		log.Fatalf("Could not write schema snapshot %v: %v", file, err)
+	}
+}
+
+// checkSnapshot warns when the config changed the schema since the last migration. Only
+// migrate writes the snapshot, so that the first migration creates the whole schema.
+func checkSnapshot(persist *persistDef, models []*_DAOModel) {
+	file := path.Join(persist.Location, SNAPSHOT_FILE)
+	if previous, err := readSnapshot(file); err != nil {
+		log.Fatal(err)
+	} else if previous == nil {
+		return
+	} else if statements, refused := previous.diff(newSnapshot(models, false), true); len(statements) != 0 || len(refused) != 0 {
+		log.Printf("The schema changed since %v was written, run gocql-gen migrate to write its migration", file)
+	}
+}
+
+// migrate writes the statements taking the schema of the snapshot to the schema of the
+// config as the next numbered migration, then records the new schema. Without a snapshot
+// the migration creates the whole schema. Tables and types removed from the config are
+// only dropped with drop.
+func migrate(persist *persistDef, models []*_DAOModel, name string, drop bool) {
+	file := path.Join(persist.Location, SNAPSHOT_FILE)
+	previous, err := readSnapshot(file)
+	if err != nil {
+		log.Fatal(err)
+	} else if previous == nil {
+		previous = &schemaSnapshot{}
+	}
+
+	runtimeMigrations(models)
+	current := newSnapshot(models, false)
+	statements, refused := previous.diff(current, drop)
+	if len(refused) != 0 {
+		log.Fatalf("The migration was refused, Cassandra can not apply these changes in place:\n  %v", strings.Join(refused, "\n  "))
+	} else if len(statements) == 0 {
+		log.Printf("The schema matches %v, there is nothing to migrate", file)
+		return
+	}
+
+	dir := path.Join(persist.Location, MIGRATIONS_DIR)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalf("Could not create %v: %v", dir, err)
+	}
+
+	migration := path.Join(dir, fmt.Sprintf("%04d_%v.cql", nextMigration(dir), migrationName(name)))
+	cql := fmt.Sprintf("-- Generated by gocql-gen migrate from the changes to %v\n\n%v\n", SNAPSHOT_FILE, strings.Join(statements, "\n\n"))
+	if err := os.WriteFile(migration, []byte(cql), 0644); err != nil {
+		log.Fatalf("Could not write migration %v: %v", migration, err)
+	}
+	writeSnapshot(file, current)
+	log.Printf("Wrote %v", migration)
+}
+
+// migrationName turns the name given to migrate into the snake case file name of the
+// migration, dropping everything but letters, digits and underscores.
+func migrationName(name string) string {
+	name = MIGRATION_NAME_REGEX.ReplaceAllString(snakeName(strings.TrimSpace(name)), "_")
+	if name = strings.Trim(name, "_"); name == "" {
+		return "migration"
+	}
+	return name
+}
+
+// runtimeMigrations reports whether the migrations apply to a keyspace picked at runtime.
+// DAOs picking their keyspace at runtime can not share migrations with DAOs of a literal
+// keyspace, since Migrate would apply those again for every keyspace it is given.
+func runtimeMigrations(models []*_DAOModel) bool {
+	runtime, literal := false, false
+	for _, m := range models {
+		runtime, literal = runtime || m.RuntimeKeyspace, literal || !m.RuntimeKeyspace
+	}
+	if runtime && literal {
+		log.Fatalf("Migrations can not mix tables picking their keyspace at runtime with tables of a literal keyspace, generate them from separate configs")
+	}
+	return runtime
+}
+
+// nextMigration returns the number following the highest numbered migration in dir.
+func nextMigration(dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Fatalf("Could not read %v: %v", dir, err)
+	}
+
+	last := 0
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".cql") {
+			continue
+		} else if n, err := strconv.Atoi(strings.SplitN(e.Name(), "_", 2)[0]); err == nil && n > last {
+			last = n
+		}
+	}
+	return last + 1
+}
+
+// diff returns the statements migrating s to current in the order Cassandra accepts them:
+// views and indexes that change are dropped first, types are created before the tables
+// using them and views are created last. Tables and types no longer configured are dropped
+// after the tables using them when drop is set, and refused otherwise. Changes that can not
+// be applied in place are refused with the reason.
+func (s *schemaSnapshot) diff(current *schemaSnapshot, drop bool) (statements, refused []string) {
+	var dropViews, dropIndexes, types, tables, alters, drops, dropTypes, indexes, views []string
+
+	previousTypes := make(map[string]*typeSnapshot)
+	for _, t := range s.Types {
+		previousTypes[t.Keyspace+"."+t.Name] = t
+	}
+	for _, t := range current.Types {
+		name := t.Keyspace + "." + t.Name
+		previous, ok := previousTypes[name]
+		delete(previousTypes, name)
+		if !ok {
+			types = append(types, t.CQL)
+			continue
+		}
+
+		added, changed, removed := diffFields(previous.Fields, t.Fields)
+		for _, f := range added {
+			types = append(types, fmt.Sprintf("ALTER TYPE %v ADD %v %v;", name, f.Name, f.Type))
+		}
+		for _, f := range changed {
+			refused = append(refused, fmt.Sprintf("type %v field %v changes from %v to %v, the type of a field can not be changed", name, f[0].Name, f[0].Type, f[1].Type))
+		}
+		for _, f := range removed {
+			refused = append(refused, fmt.Sprintf("type %v drops field %v, fields can not be dropped from a type", name, f.Name))
+		}
+	}
+
+	previousTables := make(map[string]*tableSnapshot)
+	for _, t := range s.Tables {
+		previousTables[t.Keyspace+"."+t.Name] = t
+	}
+	for _, t := range current.Tables {
+		name := t.Keyspace + "." + t.Name
+		previous, ok := previousTables[name]
+		delete(previousTables, name)
+		if !ok {
+			tables = append(tables, t.CQL)
+			previous = &tableSnapshot{}
+		} else if strings.Join(previous.PartitionKeys, ", ") != strings.Join(t.PartitionKeys, ", ") {
+			refused = append(refused, fmt.Sprintf("table %v changes its partition key from (%v) to (%v), the primary key of a table can not be changed, create a new table and copy the rows",
+				name, strings.Join(previous.PartitionKeys, ", "), strings.Join(t.PartitionKeys, ", ")))
+			continue
+		} else if strings.Join(previous.Clustering, ", ") != strings.Join(t.Clustering, ", ") {
+			refused = append(refused, fmt.Sprintf("table %v changes its clustering from (%v) to (%v), the clustering columns and order of a table can not be changed, create a new table and copy the rows",
+				name, strings.Join(previous.Clustering, ", "), strings.Join(t.Clustering, ", ")))
+			continue
+		} else {
+			added, changed, removed := diffFields(previous.Columns, t.Columns)
+			for _, c := range added {
+				if c.Static {
+					alters = append(alters, fmt.Sprintf("ALTER TABLE %v ADD %v %v STATIC;", name, c.Name, c.Type))
+				} else {
+					alters = append(alters, fmt.Sprintf("ALTER TABLE %v ADD %v %v;", name, c.Name, c.Type))
+				}
+			}
+			for _, c := range changed {
+				if c[0].Type != c[1].Type {
+					refused = append(refused, fmt.Sprintf("table %v column %v changes from %v to %v, the type of a column can not be changed, add a new column instead", name, c[0].Name, c[0].Type, c[1].Type))
+				} else {
+					refused = append(refused, fmt.Sprintf("table %v column %v changes whether it is static, a column can not be made static or regular, add a new column instead", name, c[0].Name))
+				}
+			}
+			for _, c := range removed {
+				drops = append(drops, fmt.Sprintf("ALTER TABLE %v DROP %v;", name, c.Name))
+			}
+			if options := diffOptions(previous.Options, t.Options); len(options) != 0 {
+				alters = append(alters, fmt.Sprintf("ALTER TABLE %v WITH %v;", name, strings.Join(options, "\n    AND ")))
+			}
+		}
+
+		created, dropped := diffStatements(previous.Indexes, t.Indexes)
+		indexes = append(indexes, created...)
+		for _, index := range dropped {
+			dropIndexes = append(dropIndexes, fmt.Sprintf("DROP INDEX IF EXISTS %v.%v;", t.Keyspace, index))
+		}
+
+		created, dropped = diffStatements(previous.Views, t.Views)
+		views = append(views, created...)
+		for _, view := range dropped {
+			dropViews = append(dropViews, fmt.Sprintf("DROP MATERIALIZED VIEW IF EXISTS %v.%v;", t.Keyspace, view))
+		}
+	}
+
+	for _, t := range s.Tables {
+		if _, ok := previousTables[t.Keyspace+"."+t.Name]; !ok {
+			continue
+		} else if !drop {
+			refused = append(refused, fmt.Sprintf("table %v.%v is no longer configured, migrate with -drop to drop it and its rows", t.Keyspace, t.Name))
+			continue
+		}
+		for _, view := range t.Views {
+			dropViews = append(dropViews, fmt.Sprintf("DROP MATERIALIZED VIEW IF EXISTS %v.%v;", t.Keyspace, view.Name))
+		}
+		drops = append(drops, fmt.Sprintf("DROP TABLE IF EXISTS %v.%v;", t.Keyspace, t.Name))
+	}
+
+	for i := len(s.Types) - 1; i >= 0; i-- {
+		if t := s.Types[i]; previousTypes[t.Keyspace+"."+t.Name] == nil {
+			continue
+		} else if !drop {
+			refused = append(refused, fmt.Sprintf("type %v.%v is no longer configured, migrate with -drop to drop it", t.Keyspace, t.Name))
+		} else {
+			dropTypes = append(dropTypes, fmt.Sprintf("DROP TYPE IF EXISTS %v.%v;", t.Keyspace, t.Name))
+		}
+	}
+
+	for _, group := range [][]string{dropViews, dropIndexes, types, tables, alters, drops, dropTypes, indexes, views} {
+		statements = append(statements, group...)
+	}
+	return statements, refused
+}
+
+// diffFields compares the columns or fields of a table or type by name.
+func diffFields(previous, current []*fieldSnapshot) (added []*fieldSnapshot, changed [][2]*fieldSnapshot, removed []*fieldSnapshot) {
+	types := make(map[string]*fieldSnapshot)
+	for _, f := range previous {
+		types[f.Name] = f
+	}
+	for _, f := range current {
+		if p, ok := types[f.Name]; !ok {
+			added = append(added, f)
+		} else if p.Type != f.Type || p.Static != f.Static {
+			changed = append(changed, [2]*fieldSnapshot{p, f})
+		}
+		delete(types, f.Name)
+	}
+	for _, f := range previous {
+		if _, ok := types[f.Name]; ok {
+			removed = append(removed, f)
+		}
+	}
+	return added, changed, removed
+}
+
+// diffOptions returns the table options that change, resetting those no longer configured
+// to the default of Cassandra.
+func diffOptions(previous, current []string) []string {
+	options := make(map[string]string)
+	for _, o := range previous {
+		options[optionName(o)] = o
+	}
+
+	res := make([]string, 0)
+	for _, o := range current {
+		if options[optionName(o)] != o {
+			res = append(res, o)
+		}
+		delete(options, optionName(o))
+	}
+	for _, o := range previous {
+		if _, ok := options[optionName(o)]; ok {
+			res = append(res, optionName(o)+" = "+TABLE_OPTION_DEFAULTS[optionName(o)])
+		}
+	}
+	return res
+}
+
+func optionName(clause string) string {
+	return strings.TrimSpace(strings.SplitN(clause, "=", 2)[0])
+}
+
+// diffStatements returns the statements of the indexes or views to create and the names
+// of those to drop, one that changes is dropped and created again.
+func diffStatements(previous, current []*statementSnapshot) (created, dropped []string) {
+	statements := make(map[string]string)
+	for _, s := range previous {
+		statements[s.Name] = s.CQL
+	}
+	for _, s := range current {
+		if cql, ok := statements[s.Name]; !ok || cql != s.CQL {
+			created = append(created, s.CQL)
+		}
+		if cql, ok := statements[s.Name]; ok && cql != s.CQL {
+			dropped = append(dropped, s.Name)
+		}
+		delete(statements, s.Name)
+	}
+	for _, s := range previous {
+		if _, ok := statements[s.Name]; ok {
+			dropped = append(dropped, s.Name)
+		}
+	}
+	return created, dropped
+}
+
+// writeMigrationRunner generates Migrate next to the migrations it embeds, once migrate
+// wrote the first of them. When the DAOs pick their keyspace at runtime Migrate takes the
+// keyspace too, replacing the {keyspace} placeholder of the migrations with it.
+func writeMigrationRunner(persist *persistDef, models []*_DAOModel) {
+	if scripts, _ := filepath.Glob(path.Join(persist.Location, MIGRATIONS_DIR, "*.cql")); len(scripts) == 0 {
+		return
+	}
+
+	keyspace, params, where := fmt.Sprintf(`
+  keyspace := %q`, persist.Keyspace), "", persist.Keyspace+".schema_migrations"
+	if runtimeMigrations(models) {
+		keyspace, params, where = "", ", keyspace string", "keyspace.schema_migrations"
+	}
+
+	seen := make(map[string]bool)
+	for _, m := range models {
+		if m.keyspaceOptions == nil || seen[m.keyspace()] {
+			continue
+		}
+		seen[m.keyspace()] = true
+		keyspace += fmt.Sprintf(`
+  if err := migrationDDL(ctx, session, inKeyspace(keyspace, %v)); err != nil {
+    return err
+  }`, "`"+m.keyspaceOptions.definition(m.keyspace())+"`")
+	}
+
+	source := fmt.Sprintf(_MigrateTemplate, persist.Package, MIGRATIONS_DIR, where, keyspace, params)
+	file := path.Join(persist.Location, "migrate_gen.go")
+	if res, err := format.Source([]byte(source)); err != nil {
+		log.Fatalf("Error formatting migration runner: %v\n%v", err, source)
+	} else if err := os.WriteFile(file, res, 0644); err != nil {
+		log.Fatalf("Could not write %v: %v", file, err)
+	}
+}
+
+const _MigrateTemplate = `// Code generated by "gocql-gen"; DO NOT EDIT THIS FILE
+package %[1]v
+
+import (
+  "context"
+  "embed"
+  "fmt"
+  "path"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/gocql/gocql"
+)
+
+//go:embed %[2]v/*.cql
+var migrations embed.FS
+
+// migrationLockTTL bounds how long the lock of a migrating instance that died is held,
+// the instance holding the lock renews it after every statement.
+const migrationLockTTL = 300
+
+// Migrate applies the numbered migrations written by gocql-gen migrate that were not yet
+// applied, in order, recording each statement applied in %[3]v so a
+// migration that failed resumes from the statement that failed. A lightweight transaction
+// lock lets one instance migrate at a time, the others wait for it, and the schema is
+// agreed on by the whole cluster after every statement.
+func Migrate(ctx context.Context, session *gocql.Session%[5]v) error {%[4]v
+  if err := migrationDDL(ctx, session, inKeyspace(keyspace, ` + "`" + `CREATE TABLE IF NOT EXISTS {keyspace}.schema_migrations (
+    version int PRIMARY KEY,
+    name text,
+    statements int,
+    completed timestamp
+  );` + "`" + `)); err != nil {
+    return err
+  }
+  if err := migrationDDL(ctx, session, inKeyspace(keyspace, ` + "`" + `CREATE TABLE IF NOT EXISTS {keyspace}.schema_migrations_lock (
+    id text PRIMARY KEY,
+    owner timeuuid
+  );` + "`" + `)); err != nil {
+    return err
+  }
+
+  owner := gocql.TimeUUID()
+  if err := lockMigrations(ctx, session, keyspace, owner); err != nil {
+    return err
+  }
+  defer unlockMigrations(session, keyspace, owner)
+
+  applied := make(map[int]int)
+  iter := session.Query(inKeyspace(keyspace, ` + "`" + `SELECT version, statements, completed FROM {keyspace}.schema_migrations;` + "`" + `)).WithContext(ctx).Iter()
+  var (
+    version, statements int
+    completed           time.Time
+  )
+  for iter.Scan(&version, &statements, &completed) {
+    applied[version] = statements
+    if !completed.IsZero() {
+      applied[version] = -1
+    }
+  }
+  if err := iter.Close(); err != nil {
+    return err
+  }
+
+  scripts, err := migrationScripts()
+  if err != nil {
+    return err
+  }
+  for _, script := range scripts {
+    if applied[script.version] == -1 {
+      continue
+    }
+
+    for i, cql := range script.statements {
+      if applied[script.version] > i {
+        continue
+      } else if err := migrationDDL(ctx, session, inKeyspace(keyspace, cql)); err != nil {
+        return fmt.Errorf("migration %%v failed at statement %%v: %%w", script.name, i+1, err)
+      } else if err := session.Query(inKeyspace(keyspace, ` + "`" + `INSERT INTO {keyspace}.schema_migrations (version, name, statements) VALUES (?, ?, ?);` + "`" + `),
+        script.version, script.name, i+1).WithContext(ctx).Exec(); err != nil {
+        return err
+      } else if err := renewMigrationLock(ctx, session, keyspace, owner); err != nil {
+        return err
+      }
+    }
+
+    if err := session.Query(inKeyspace(keyspace, ` + "`" + `INSERT INTO {keyspace}.schema_migrations (version, name, statements, completed) VALUES (?, ?, ?, ?);` + "`" + `),
+      script.version, script.name, len(script.statements), time.Now()).WithContext(ctx).Exec(); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// migrationDDL runs a schema statement and waits for the cluster to agree on the schema.
+func migrationDDL(ctx context.Context, session *gocql.Session, cql string) error {
+  if err := session.Query(cql).WithContext(ctx).Exec(); err != nil {
+    return err
+  }
+  return session.AwaitSchemaAgreement(ctx)
+}
+
+// inKeyspace replaces the {keyspace} placeholder of cql with keyspace.
+func inKeyspace(keyspace, cql string) string {
+  return strings.Replace(cql, "{keyspace}", keyspace, -1)
+}
+
+func lockMigrations(ctx context.Context, session *gocql.Session, keyspace string, owner gocql.UUID) error {
+  for {
+    if acquired, err := session.Query(inKeyspace(keyspace, ` + "`" + `INSERT INTO {keyspace}.schema_migrations_lock (id, owner) VALUES ('migrate', ?) IF NOT EXISTS USING TTL ?;` + "`" + `),
+      owner, migrationLockTTL).WithContext(ctx).MapScanCAS(make(map[string]interface{})); err != nil {
+      return err
+    } else if acquired {
+      return nil
+    }
+
+    select {
+    case <-ctx.Done():
+      return ctx.Err()
+    case <-time.After(time.Second):
+    }
+  }
+}
+
+func unlockMigrations(session *gocql.Session, keyspace string, owner gocql.UUID) {
+  session.Query(inKeyspace(keyspace, ` + "`" + `DELETE FROM {keyspace}.schema_migrations_lock WHERE id = 'migrate' IF owner = ?;` + "`" + `), owner).
+    MapScanCAS(make(map[string]interface{}))
+}
+
+func renewMigrationLock(ctx context.Context, session *gocql.Session, keyspace string, owner gocql.UUID) error {
+  if held, err := session.Query(inKeyspace(keyspace, ` + "`" + `UPDATE {keyspace}.schema_migrations_lock USING TTL ? SET owner = ? WHERE id = 'migrate' IF owner = ?;` + "`" + `),
+    migrationLockTTL, owner, owner).WithContext(ctx).MapScanCAS(make(map[string]interface{})); err != nil {
+    return err
+  } else if !held {
+    return fmt.Errorf("the migration lock expired and was taken by another instance")
+  }
+  return nil
+}
+
+type migrationScript struct {
+  version    int
+  name       string
+  statements []string
+}
+
+// migrationScripts reads the embedded migrations ordered by their number.
+func migrationScripts() ([]*migrationScript, error) {
+  files, err := migrations.ReadDir("%[2]v")
+  if err != nil {
+    return nil, err
+  }
+
+  scripts := make([]*migrationScript, 0, len(files))
+  for _, f := range files {
+    version, err := strconv.Atoi(strings.SplitN(f.Name(), "_", 2)[0])
+    if err != nil {
+      return nil, fmt.Errorf("migration %%v is not numbered: %%w", f.Name(), err)
+    }
+
+    data, err := migrations.ReadFile(path.Join("%[2]v", f.Name()))
+    if err != nil {
+      return nil, err
+    }
+    scripts = append(scripts, &migrationScript{version: version, name: f.Name(), statements: migrationStatements(string(data))})
+  }
+
+  sort.Slice(scripts, func(i, j int) bool { return scripts[i].version < scripts[j].version })
+  return scripts, nil
+}
+
+// migrationStatements splits a script into its statements, each ending with a semicolon at
+// the end of a line. Lines starting with -- are comments.
+func migrationStatements(script string) []string {
+  statements := make([]string, 0)
+  current := make([]string, 0)
+  for _, line := range strings.Split(script, "\n") {
+    trimmed := strings.TrimSpace(line)
+    if strings.HasPrefix(trimmed, "--") || (trimmed == "" && len(current) == 0) {
+      continue
+    }
+
+    current = append(current, line)
+    if strings.HasSuffix(trimmed, ";") {
+      statements = append(statements, strings.TrimSpace(strings.Join(current, "\n")))
+      current = current[:0]
+    }
+  }
+
+  if rest := strings.TrimSpace(strings.Join(current, "\n")); rest != "" {
+    statements = append(statements, rest)
+  }
+  return statements
+}
+`
+
+// SCHEMA_CQL_FILE holds the DDL written by -emit-cql.
+const SCHEMA_CQL_FILE = "schema.cql"
+
+// writeSchemaCQL writes the statements the DAOs run in Init next to them, ordered so
+// keyspaces come before the types, types before the tables using them, and tables before
+// their indexes and views.
+func writeSchemaCQL(persist *persistDef, models []*_DAOModel) {
+	keyspaces, seen := make([]string, 0), make(map[string]bool)
+	for _, m := range models {
+		if m.keyspaceOptions != nil && !seen[m.Keyspace] {
+			seen[m.Keyspace] = true
+			keyspaces = append(keyspaces, m.keyspaceOptions.definition(m.Keyspace))
+		}
+	}
+
+	snapshot := newSnapshot(models, true)
+	types, tables, dependents := make([]string, 0), make([]string, 0), make([]string, 0)
+	for _, t := range snapshot.Types {
+		types = append(types, t.CQL)
+	}
+	for _, t := range snapshot.Tables {
+		tables = append(tables, t.CQL)
+		for _, index := range t.Indexes {
+			dependents = append(dependents, index.CQL)
+		}
+	}
+	for _, t := range snapshot.Tables {
+		for _, view := range t.Views {
+			dependents = append(dependents, view.CQL)
+		}
+	}
+
+	statements := make([]string, 0)
+	for _, group := range [][]string{keyspaces, types, tables, dependents} {
+		statements = append(statements, group...)
+	}
+
+	file := path.Join(persist.Location, SCHEMA_CQL_FILE)
+	cql := fmt.Sprintf("-- Code generated by \"gocql-gen\"; DO NOT EDIT THIS FILE\n\n%v\n", strings.Join(statements, "\n\n"))
+	if err := os.WriteFile(file, []byte(cql), 0644); err != nil {
+		log.Fatalf("Could not write %v: %v", file, err)
+	}
+}
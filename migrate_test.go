@@ -0,0 +1,147 @@
+package main
+
+import (
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	previous := &schemaSnapshot{
+		Types: []*typeSnapshot{
+			{Keyspace: "ks", Name: "address", Fields: []*fieldSnapshot{{Name: "street", Type: "text"}}},
+			{Keyspace: "ks", Name: "unused", Fields: []*fieldSnapshot{{Name: "street", Type: "text"}}},
+		},
+		Tables: []*tableSnapshot{
+			{
+				Keyspace: "ks", Name: "users", PartitionKeys: []string{"id"}, Options: []string{"comment = 'old'", "gc_grace_seconds = 3600"},
+				Columns: []*fieldSnapshot{{Name: "id", Type: "uuid"}, {Name: "name", Type: "text"}, {Name: "old", Type: "int"}},
+				Indexes: []*statementSnapshot{{"users_by_name", "CREATE INDEX users_by_name ON ks.users (name);"}},
+				Views:   []*statementSnapshot{{"users_by_old", "CREATE MATERIALIZED VIEW ks.users_by_old AS old;"}},
+			},
+			{Keyspace: "ks", Name: "gone", PartitionKeys: []string{"id"}, Columns: []*fieldSnapshot{{Name: "id", Type: "uuid"}},
+				Views: []*statementSnapshot{{"gone_by_id", "CREATE MATERIALIZED VIEW ks.gone_by_id AS id;"}}},
+		},
+	}
+	current := &schemaSnapshot{
+		Types: []*typeSnapshot{
+			{Keyspace: "ks", Name: "address", Fields: []*fieldSnapshot{{Name: "street", Type: "text"}, {Name: "zip", Type: "int"}}},
+			{Keyspace: "ks", Name: "phone", CQL: "CREATE TYPE ks.phone (number text);"},
+		},
+		Tables: []*tableSnapshot{
+			{
+				Keyspace: "ks", Name: "users", PartitionKeys: []string{"id"}, Options: []string{"comment = 'users'"},
+				Columns: []*fieldSnapshot{{Name: "id", Type: "uuid"}, {Name: "name", Type: "text"}, {Name: "owner", Type: "text", Static: true}, {Name: "phone", Type: "frozen<phone>"}},
+				Indexes: []*statementSnapshot{{"users_by_name", "CREATE CUSTOM INDEX users_by_name ON ks.users (name) USING 'StorageAttachedIndex';"}},
+			},
+			{Keyspace: "ks", Name: "events", CQL: "CREATE TABLE ks.events (id uuid PRIMARY KEY);", PartitionKeys: []string{"id"}},
+		},
+	}
+
+	statements, refused := previous.diff(current, true)
+	expected := []string{
+		"DROP MATERIALIZED VIEW IF EXISTS ks.users_by_old;",
+		"DROP MATERIALIZED VIEW IF EXISTS ks.gone_by_id;",
+		"DROP INDEX IF EXISTS ks.users_by_name;",
+		"ALTER TYPE ks.address ADD zip int;",
+		"CREATE TYPE ks.phone (number text);",
+		"CREATE TABLE ks.events (id uuid PRIMARY KEY);",
+		"ALTER TABLE ks.users ADD owner text STATIC;",
+		"ALTER TABLE ks.users ADD phone frozen<phone>;",
+		"ALTER TABLE ks.users WITH comment = 'users'\n    AND gc_grace_seconds = 864000;",
+		"ALTER TABLE ks.users DROP old;",
+		"DROP TABLE IF EXISTS ks.gone;",
+		"DROP TYPE IF EXISTS ks.unused;",
+		"CREATE CUSTOM INDEX users_by_name ON ks.users (name) USING 'StorageAttachedIndex';",
+	}
+	if !reflect.DeepEqual(statements, expected) {
+		t.Errorf("diff is\n  %v\nexpected\n  %v", strings.Join(statements, "\n  "), strings.Join(expected, "\n  "))
+	}
+	if len(refused) != 0 {
+		t.Errorf("diff refused %v", refused)
+	}
+
+	if statements, refused := current.diff(current, true); len(statements) != 0 || len(refused) != 0 {
+		t.Errorf("an unchanged schema diffs to %v and refuses %v", statements, refused)
+	}
+
+	_, refused = previous.diff(current, false)
+	expected = []string{
+		"table ks.gone is no longer configured, migrate with -drop to drop it and its rows",
+		"type ks.unused is no longer configured, migrate with -drop to drop it",
+	}
+	if !reflect.DeepEqual(refused, expected) {
+		t.Errorf("diff without drop refused %v, expected %v", refused, expected)
+	}
+}
+
+func TestDiffRefusals(t *testing.T) {
+	table := func(partition []string, clustering []string, columns ...*fieldSnapshot) *schemaSnapshot {
+		return &schemaSnapshot{Tables: []*tableSnapshot{{Keyspace: "ks", Name: "t", PartitionKeys: partition, Clustering: clustering, Columns: columns}}}
+	}
+	udt := func(fields ...*fieldSnapshot) *schemaSnapshot {
+		return &schemaSnapshot{Types: []*typeSnapshot{{Keyspace: "ks", Name: "u", Fields: fields}}}
+	}
+	id, at := &fieldSnapshot{Name: "id", Type: "uuid"}, &fieldSnapshot{Name: "at", Type: "timestamp"}
+
+	for _, test := range []struct {
+		name              string
+		previous, current *schemaSnapshot
+		refusal           string
+	}{
+		{"partition key", table([]string{"id"}, nil, id, at), table([]string{"id", "at"}, nil, id, at),
+			"table ks.t changes its partition key from (id) to (id, at)"},
+		{"clustering order", table([]string{"id"}, []string{"at ASC"}, id, at), table([]string{"id"}, []string{"at DESC"}, id, at),
+			"table ks.t changes its clustering from (at ASC) to (at DESC)"},
+		{"column type", table([]string{"id"}, nil, id, at), table([]string{"id"}, nil, id, &fieldSnapshot{Name: "at", Type: "date"}),
+			"table ks.t column at changes from timestamp to date"},
+		{"static column", table([]string{"id"}, nil, id, at), table([]string{"id"}, nil, id, &fieldSnapshot{Name: "at", Type: "timestamp", Static: true}),
+			"table ks.t column at changes whether it is static"},
+		{"field type", udt(id, at), udt(id, &fieldSnapshot{Name: "at", Type: "date"}),
+			"type ks.u field at changes from timestamp to date"},
+		{"dropped field", udt(id, at), udt(id),
+			"type ks.u drops field at"},
+	} {
+		statements, refused := test.previous.diff(test.current, true)
+		if len(statements) != 0 {
+			t.Errorf("%v: diff migrated with %v", test.name, statements)
+		}
+		if len(refused) != 1 || !strings.HasPrefix(refused[0], test.refusal) {
+			t.Errorf("%v: diff refused %v, expected %v", test.name, refused, test.refusal)
+		}
+	}
+}
+
+func TestMigrationName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Add age":          "add_age",
+		"AddAge":           "add_age",
+		"add-users/emails": "add_users_emails",
+		" drop 'old' ":     "drop_old",
+		"über":             "ber",
+		"!!":               "migration",
+		"":                 "migration",
+	} {
+		if actual := migrationName(name); actual != expected {
+			t.Errorf("migrationName(%q) is %q, expected %q", name, actual, expected)
+		}
+	}
+}
+
+func TestCheckSnapshot(t *testing.T) {
+	persist := &persistDef{Location: t.TempDir()}
+	checkSnapshot(persist, nil)
+	if snapshot, err := readSnapshot(path.Join(persist.Location, SNAPSHOT_FILE)); err != nil || snapshot != nil {
+		t.Errorf("generating wrote the snapshot %+v, %v", snapshot, err)
+	}
+}
+
+func TestRuntimeMigrations(t *testing.T) {
+	if runtimeMigrations([]*_DAOModel{{Keyspace: "a"}, {Keyspace: "b"}}) {
+		t.Errorf("tables of literal keyspaces were migrated at runtime")
+	}
+	if !runtimeMigrations([]*_DAOModel{{Keyspace: "a", RuntimeKeyspace: true}, {Keyspace: "b", RuntimeKeyspace: true}}) {
+		t.Errorf("tables picking their keyspace at runtime were not migrated at runtime")
+	}
+}
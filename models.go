@@ -0,0 +1,510 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var TABLE_DIRECTIVE_REGEX = regexp.MustCompile(`^gocql:(table|type)\s+([\w.]+)\s*$`)
+var MODULE_REGEX = regexp.MustCompile(`(?m)^module\s+(\S+)\s*$`)
+
+// CQL_TYPES maps the Go types a model field may have to the cql type stored for it.
+// Unsigned integers take the next wider type, gocql refuses values that do not fit.
+var CQL_TYPES = map[string]string{
+	"string": "text", "int": "int", "int64": "bigint", "int32": "int", "int16": "smallint", "int8": "tinyint", "rune": "int",
+	"uint": "bigint", "uint64": "bigint", "uint32": "bigint", "uint16": "int", "uint8": "smallint", "byte": "smallint",
+	"bool": "boolean", "float32": "float", "float64": "double", "[]byte": "blob", "time.Duration": "bigint",
+	"gocql.UUID": "uuid", "*gocql.UUID": "uuid", "time.Time": "timestamp", "*time.Time": "timestamp",
+}
+
+type modelStruct struct {
+	Name      string
+	Directive string
+	CqlName   string
+	File      *modelFile
+	Fields    []*ast.Field
+	Table     bool
+}
+
+type modelFile struct {
+	Name    string
+	Imports map[string]string
+}
+
+// modelReader turns the annotated structs of a Go package into tables and types.
+type modelReader struct {
+	Dir     string
+	Out     string
+	Package string
+	Structs map[string]*modelStruct
+	Scalars map[string]string
+	Imports map[string]string
+}
+
+// loadModels reads the structs with cql tags of the Go package in dir. Structs with
+// partition key fields become tables, the others user defined types. A struct may
+// name its table or type with a "gocql:table keyspace.name" or "gocql:type name"
+// comment, otherwise the snake case of its name is used.
+func loadModels(dir, out string, persist *persistDef) {
+	if persist.ModelGeneration != nil {
+		log.Fatalf("Models are read from %v, ModelGeneration would overwrite them", dir)
+	}
+
+	r := &modelReader{Dir: dir, Out: out, Structs: make(map[string]*modelStruct), Scalars: make(map[string]string), Imports: make(map[string]string)}
+	r.parse()
+	if len(r.Structs) == 0 {
+		log.Fatalf("No struct in %v has cql tags", dir)
+	}
+
+	if same, err := sameDir(dir, out); err != nil {
+		log.Fatalf("Could not resolve %v: %v", dir, err)
+	} else if !same {
+		persist.ModelImport = r.Package
+		r.Imports[r.Package] = importPath(dir)
+	}
+
+	names := make([]string, 0, len(r.Structs))
+	for name := range r.Structs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return r.Structs[names[i]].File.Name < r.Structs[names[j]].File.Name || r.Structs[names[i]].File.Name == r.Structs[names[j]].File.Name && names[i] < names[j]
+	})
+
+	tables := make(map[*modelStruct]*tableDef)
+	for _, name := range names {
+		s := r.Structs[name]
+		keyspace, i := "", strings.LastIndex(s.Directive, ".")
+		if i != -1 {
+			keyspace = s.Directive[:i]
+			if persist.Keyspace == "" {
+				persist.Keyspace = keyspace
+			}
+		}
+		s.CqlName = s.Directive[i+1:]
+		if s.CqlName == "" {
+			s.CqlName = snakeName(s.Name)
+		}
+
+		if s.Table {
+			tables[s] = &tableDef{Model: s.Name, Table: s.CqlName, DAO: s.Name + "DAO", GeneratedName: s.CqlName}
+			if keyspace != persist.Keyspace {
+				tables[s].Keyspace = keyspace
+			}
+		} else {
+			mergeType(persist, &typeDef{Name: s.CqlName, GoName: s.Name})
+		}
+	}
+
+	for _, name := range names {
+		s := r.Structs[name]
+		columns := r.columns(s)
+		if table, ok := tables[s]; ok {
+			table.Columns = columns
+			r.verify(s, table.Columns, persist)
+			if merged := mergeTable(persist, table); merged != table && table.Keyspace != "" {
+				merged.Keyspace = table.Keyspace
+			}
+		} else {
+			for _, udt := range persist.Types {
+				if udt.Name == s.CqlName {
+					udt.Fields = columns
+				}
+			}
+		}
+	}
+
+	for _, name := range names {
+		if s := r.Structs[name]; !s.Table {
+			for _, udt := range persist.Types {
+				if udt.Name == s.CqlName {
+					r.verify(s, udt.Fields, persist)
+				}
+			}
+		}
+	}
+
+	used := make([]string, 0, len(r.Imports))
+	for name, path := range r.Imports {
+		if name != "gocql" && name != "time" {
+			used = append(used, importSpec(name, path))
+		}
+	}
+	sort.Strings(used)
+	for _, spec := range used {
+		found := false
+		for _, im := range persist.AdditionalImports {
+			found = found || im == spec
+		}
+		if !found {
+			persist.AdditionalImports = append(persist.AdditionalImports, spec)
+		}
+	}
+
+	if persist.Package == "" {
+		persist.Package = packageName(out)
+	}
+}
+
+// parse collects the structs of the package with at least one cql tag.
+func (r *modelReader) parse() {
+	files, err := filepath.Glob(filepath.Join(r.Dir, "*.go"))
+	if err != nil {
+		log.Fatalf("Could not list go files in %v: %v", r.Dir, err)
+	}
+	sort.Strings(files)
+
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") || strings.HasSuffix(file, "_gen.go") {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			log.Fatalf("Could not parse %v: %v", file, err)
+		}
+		r.Package = f.Name.Name
+
+		mf := &modelFile{Name: file, Imports: make(map[string]string)}
+		for _, im := range f.Imports {
+			path, _ := strconv.Unquote(im.Path.Value)
+			name := path[strings.LastIndex(path, "/")+1:]
+			if im.Name != nil {
+				name = im.Name.Name
+			}
+			mf.Imports[name] = path
+		}
+
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ident, ok := ts.Type.(*ast.Ident); ok && basicType(ident.Name) {
+					r.Scalars[ts.Name.Name] = ident.Name
+				}
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+
+				s := &modelStruct{Name: ts.Name.Name, File: mf}
+				for _, doc := range []*ast.CommentGroup{gen.Doc, ts.Doc} {
+					if doc == nil {
+						continue
+					}
+					for _, c := range doc.List {
+						text := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(c.Text, "//"), " "))
+						if match := TABLE_DIRECTIVE_REGEX.FindStringSubmatch(text); match != nil {
+							s.Directive = match[2]
+							s.Table = match[1] == "table"
+						}
+					}
+				}
+
+				for _, field := range st.Fields.List {
+					if name, opts, ok := cqlTag(field); ok && name != "-" {
+						if len(field.Names) != 1 {
+							log.Fatalf("Field of %v with cql tag %v must declare exactly one name", s.Name, name)
+						}
+						for _, opt := range opts {
+							s.Table = s.Table || strings.TrimSpace(opt) == "partition"
+						}
+						s.Fields = append(s.Fields, field)
+					}
+				}
+
+				if len(s.Fields) > 0 {
+					r.Structs[s.Name] = s
+				}
+			}
+		}
+	}
+}
+
+// cqlTag splits a `cql:"name,option,..."` tag into the column name and its options.
+func cqlTag(field *ast.Field) (string, []string, bool) {
+	if field.Tag == nil {
+		return "", nil, false
+	}
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return "", nil, false
+	}
+	value, ok := reflect.StructTag(tag).Lookup("cql")
+	if !ok {
+		return "", nil, false
+	}
+	parts := strings.Split(value, ",")
+	return strings.TrimSpace(parts[0]), parts[1:], true
+}
+
+// columns builds the column definitions of a struct from the tags of its fields.
+func (r *modelReader) columns(s *modelStruct) []*columnDef {
+	columns := make([]*columnDef, 0, len(s.Fields))
+	for _, field := range s.Fields {
+		name, opts, _ := cqlTag(field)
+		column := &columnDef{Name: name, Field: field.Names[0].Name}
+		if column.Name == "" {
+			column.Name = snakeName(column.Field)
+		}
+
+		for i, opt := range opts {
+			opt = strings.TrimSpace(opt)
+			switch {
+			case opt == "partition" || opt == "cluster" || opt == "cluster-asc" || opt == "cluster-desc" || opt == "static":
+				if !s.Table {
+					log.Fatalf("Field %v of type %v can not be a %v key", field.Names[0].Name, s.Name, opt)
+				}
+				column.Key = opt
+			case strings.HasPrefix(opt, "serializer="):
+				column.Serializer = strings.TrimPrefix(opt, "serializer=")
+			case strings.HasPrefix(opt, "errors="):
+				column.SerializationErrors = strings.TrimPrefix(opt, "errors=")
+			case strings.HasPrefix(opt, "type="):
+				// Types such as map<text, int> hold commas, so type= takes the rest of the tag.
+				column.CqlType = strings.TrimPrefix(strings.TrimSpace(strings.Join(opts[i:], ",")), "type=")
+			default:
+				log.Fatalf("Field %v of %v has unknown cql tag option %v", field.Names[0].Name, s.Name, opt)
+			}
+			if column.CqlType != "" {
+				break
+			}
+		}
+
+		cqlType, deserializeTo := r.cqlType(s, field.Type)
+		if column.CqlType == "" {
+			column.CqlType = cqlType
+		}
+		if strings.Contains(column.CqlType, "blob") && cqlType == column.CqlType {
+			column.DeserializeFromBlob = deserializeTo
+		}
+		if column.DeserializeFromBlob != "" && column.Serializer == "" {
+			log.Fatalf("Field %v of %v is a %v that cql can not hold, declare the serializer=json, gob, protobuf or msgpack storing it as a blob", field.Names[0].Name, s.Name, r.qualify(s, field.Type, false))
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// cqlType infers the cql type of a field. Fields of types cql can not hold are
+// stored as blobs, also returning the type the blobs deserialize to.
+func (r *modelReader) cqlType(s *modelStruct, expr ast.Expr) (string, string) {
+	if t, ok := r.scalarType(s, expr); ok {
+		return t, ""
+	} else if udt := r.udt(expr); udt != nil {
+		return "frozen<" + udt.CqlName + ">", ""
+	}
+
+	element := func(expr ast.Expr) (string, string) {
+		if t, ok := r.scalarType(s, expr); ok && t != "blob" {
+			return t, ""
+		} else if udt, ok := expr.(*ast.Ident); ok && r.Structs[udt.Name] != nil && !r.Structs[udt.Name].Table {
+			return "frozen<" + r.Structs[udt.Name].CqlName + ">", ""
+		}
+		return "blob", r.qualify(s, expr, true)
+	}
+
+	switch t := expr.(type) {
+	case *ast.ArrayType:
+		if t.Len == nil {
+			elementType, deserializeTo := element(t.Elt)
+			return "list<" + elementType + ">", deserializeTo
+		}
+	case *ast.MapType:
+		if keyType, _ := element(t.Key); keyType != "blob" && !strings.HasPrefix(keyType, "frozen") {
+			valueType, deserializeTo := element(t.Value)
+			return "map<" + keyType + ", " + valueType + ">", deserializeTo
+		}
+	}
+	return "blob", r.qualify(s, expr, true)
+}
+
+// scalarType returns the cql type of a field holding a Go scalar, or a type the models
+// package declares on one. Scalars cql has no type for can not be stored.
+func (r *modelReader) scalarType(s *modelStruct, expr ast.Expr) (string, bool) {
+	name := r.qualify(s, expr, false)
+	if ident, ok := expr.(*ast.Ident); ok && r.Scalars[ident.Name] != "" {
+		name = r.Scalars[ident.Name]
+	}
+
+	if t, ok := CQL_TYPES[name]; ok {
+		return t, true
+	} else if basicType(name) {
+		log.Fatalf("Struct %v has a field of type %v that can not be stored in cql", s.Name, r.qualify(s, expr, false))
+	}
+	return "", false
+}
+
+// basicType reports whether name is one of the predeclared scalar types of Go.
+func basicType(name string) bool {
+	if obj, ok := types.Universe.Lookup(name).(*types.TypeName); ok {
+		_, ok = obj.Type().(*types.Basic)
+		return ok
+	}
+	return false
+}
+
+// udt returns the struct of the user defined type a field holds.
+func (r *modelReader) udt(expr ast.Expr) *modelStruct {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		if s := r.Structs[ident.Name]; s != nil && !s.Table {
+			return s
+		}
+	}
+	return nil
+}
+
+// qualify prints a field type as the generated DAO refers to it, qualifying the
+// types of the model package and recording the imports the type needs.
+func (r *modelReader) qualify(s *modelStruct, expr ast.Expr, record bool) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if types.Universe.Lookup(t.Name) != nil || r.isLocal() {
+			return t.Name
+		}
+		return r.Package + "." + t.Name
+	case *ast.StarExpr:
+		return "*" + r.qualify(s, t.X, record)
+	case *ast.ArrayType:
+		if t.Len == nil {
+			return "[]" + r.qualify(s, t.Elt, record)
+		}
+	case *ast.MapType:
+		return "map[" + r.qualify(s, t.Key, record) + "]" + r.qualify(s, t.Value, record)
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok {
+			if record {
+				path, ok := s.File.Imports[pkg.Name]
+				if !ok {
+					log.Fatalf("Field type %v.%v of %v does not name an imported package", pkg.Name, t.Sel.Name, s.Name)
+				}
+				r.Imports[pkg.Name] = path
+			}
+			return pkg.Name + "." + t.Sel.Name
+		}
+	case *ast.InterfaceType:
+		if t.Methods == nil || len(t.Methods.List) == 0 {
+			return "interface{}"
+		}
+	}
+	log.Fatalf("Struct %v has a field of type %T that can not be stored in cql", s.Name, expr)
+	return ""
+}
+
+// isLocal reports whether the DAO is generated in the models package.
+func (r *modelReader) isLocal() bool {
+	same, _ := sameDir(r.Dir, r.Out)
+	return same
+}
+
+// verify checks the fields of a struct have the types the generated code scans the
+// columns into, since a reverse mode model is not generated to match.
+func (r *modelReader) verify(s *modelStruct, columns []*columnDef, persist *persistDef) {
+	for _, udt := range persist.Types {
+		if udt.GoName == "" {
+			udt.GoName = goName(udt.Name)
+		}
+	}
+
+	model := &_DAOModel{ModelImport: persist.ModelImport, types: persist.Types}
+	for i, field := range s.Fields {
+		column := columns[i]
+		expected, keyGoType, container := model.goType(column.Name, column.CqlType)
+		if column.DeserializeFromBlob != "" {
+			expected = (&param{Container: container, KeyGoType: keyGoType}).serializedGoType(column.DeserializeFromBlob)
+		}
+
+		actual := r.qualify(s, field.Type, false)
+		if cqlType, ok := r.scalarType(s, field.Type); ok && cqlType == column.CqlType && column.DeserializeFromBlob == "" {
+			if actual != expected {
+				column.goType = actual
+			}
+		} else if actual != expected {
+			log.Fatalf("Field %v of %v is a %v but %v columns are read into a %v", field.Names[0].Name, s.Name, actual, column.CqlType, expected)
+		}
+	}
+}
+
+// snakeName turns a Go identifier such as UserID into the cql name user_id.
+func snakeName(name string) string {
+	runes := []rune(name)
+	var res strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			res.WriteRune('_')
+		}
+		res.WriteRune(unicode.ToLower(r))
+	}
+	return res.String()
+}
+
+func sameDir(a, b string) (bool, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	return absA == absB, nil
+}
+
+// importPath resolves the import path of the package in dir from the enclosing go.mod.
+func importPath(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		log.Fatalf("Could not resolve %v: %v", dir, err)
+	}
+
+	for root := abs; ; root = filepath.Dir(root) {
+		if mod, err := os.ReadFile(filepath.Join(root, "go.mod")); err == nil {
+			match := MODULE_REGEX.FindSubmatch(mod)
+			if match == nil {
+				log.Fatalf("%v does not declare a module", filepath.Join(root, "go.mod"))
+			}
+			rel, _ := filepath.Rel(root, abs)
+			return filepath.ToSlash(filepath.Join(string(match[1]), rel))
+		} else if filepath.Dir(root) == root {
+			log.Fatalf("Could not find the go.mod enclosing %v", dir)
+		}
+	}
+}
+
+func importSpec(name, path string) string {
+	if path[strings.LastIndex(path, "/")+1:] == name {
+		return strconv.Quote(path)
+	}
+	return name + " " + strconv.Quote(path)
+}
+
+// packageName returns the package declared by the Go files in dir.
+func packageName(dir string) string {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	for _, file := range files {
+		if f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.PackageClauseOnly); err == nil {
+			return f.Name.Name
+		}
+	}
+	log.Fatalf("Could not find the package of %v, set package in the config", dir)
+	return ""
+}
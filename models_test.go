@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadModelsScalars(t *testing.T) {
+	dir := t.TempDir()
+	source := `package models
+
+import "time"
+
+type Level int8
+
+// gocql:table ks.users
+type User struct {
+	ID     int64         ` + "`cql:\"id, partition\"`" + `
+	Level  Level         ` + "`cql:\"level\"`" + `
+	Visits uint32        ` + "`cql:\"visits\"`" + `
+	Idle   time.Duration ` + "`cql:\"idle\"`" + `
+	Age    int32         ` + "`cql:\"age\"`" + `
+	Name   string        ` + "`cql:\"name\"`" + `
+}
+`
+	if err := os.WriteFile(path.Join(dir, "models.go"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	persist := &persistDef{}
+	loadModels(dir, dir, persist)
+	if len(persist.Tables) != 1 {
+		t.Fatalf("expected the users table, got %+v", persist.Tables)
+	}
+
+	expected := []columnDef{
+		{Name: "id", Field: "ID", CqlType: "bigint", Key: "partition"},
+		{Name: "level", Field: "Level", CqlType: "tinyint", goType: "Level"},
+		{Name: "visits", Field: "Visits", CqlType: "bigint", goType: "uint32"},
+		{Name: "idle", Field: "Idle", CqlType: "bigint", goType: "time.Duration"},
+		{Name: "age", Field: "Age", CqlType: "int", goType: "int32"},
+		{Name: "name", Field: "Name", CqlType: "text"},
+	}
+	columns := persist.Tables[0].Columns
+	if len(columns) != len(expected) {
+		t.Fatalf("users has columns %+v", columns)
+	}
+	for i, c := range columns {
+		if *c != expected[i] {
+			t.Errorf("column %v is %+v, expected %+v", i, *c, expected[i])
+		}
+	}
+}
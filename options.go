@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type tableOptionsDef struct {
+	Compaction          map[string]interface{} `json:"compaction,omitempty"`
+	Compression         map[string]interface{} `json:"compression,omitempty"`
+	GcGraceSeconds      *int                   `json:"gcGraceSeconds,omitempty"`
+	Caching             map[string]interface{} `json:"caching,omitempty"`
+	BloomFilterFpChance *float64               `json:"bloomFilterFpChance,omitempty"`
+	DefaultTimeToLive   *int                   `json:"defaultTimeToLive,omitempty"`
+	Comment             string                 `json:"comment,omitempty"`
+}
+
+// TABLE_OPTION_DEFAULTS are the values Cassandra gives the options a table does not declare,
+// migrations reset the options removed from the config to them.
+var TABLE_OPTION_DEFAULTS = map[string]string{
+	"compaction":             "{'class': 'SizeTieredCompactionStrategy'}",
+	"compression":            "{'class': 'LZ4Compressor'}",
+	"gc_grace_seconds":       "864000",
+	"caching":                "{'keys': 'ALL', 'rows_per_partition': 'NONE'}",
+	"bloom_filter_fp_chance": "0.01",
+	"default_time_to_live":   "0",
+	"comment":                "''",
+}
+
+// COMPACTION_STRATEGIES expands the abbreviated compaction classes.
+var COMPACTION_STRATEGIES = map[string]string{
+	"STCS": "SizeTieredCompactionStrategy",
+	"LCS":  "LeveledCompactionStrategy",
+	"TWCS": "TimeWindowCompactionStrategy",
+}
+
+var COMMON_COMPACTION_OPTIONS = []string{
+	"enabled", "tombstone_threshold", "tombstone_compaction_interval", "unchecked_tombstone_compaction",
+	"only_purge_repaired_tombstones", "log_all", "min_threshold", "max_threshold",
+}
+
+// COMPACTION_OPTIONS are the sub-options of each strategy in addition to the common ones.
+var COMPACTION_OPTIONS = map[string][]string{
+	"SizeTieredCompactionStrategy": {"bucket_high", "bucket_low", "min_sstable_size"},
+	"LeveledCompactionStrategy":    {"sstable_size_in_mb", "fanout_size", "single_sstable_uplevel"},
+	"TimeWindowCompactionStrategy": {"compaction_window_unit", "compaction_window_size", "timestamp_resolution",
+		"expired_sstable_check_frequency_seconds", "unsafe_aggressive_sstable_expiration"},
+}
+
+var COMPRESSION_OPTIONS = []string{"class", "chunk_length_in_kb", "crc_check_chance", "enabled", "compression_level", "min_compress_ratio"}
+
+// validate checks the options hold values Cassandra accepts before they reach Init.
+func (o *tableOptionsDef) validate(table string) {
+	if o.Compaction != nil {
+		class, ok := o.Compaction["class"].(string)
+		if !ok {
+			log.Fatalf("Table %v compaction must declare a class, one of STCS, LCS, TWCS or a strategy class", table)
+		}
+		if strategy, ok := COMPACTION_STRATEGIES[strings.ToUpper(class)]; ok {
+			class = strategy
+			o.Compaction["class"] = class
+		}
+
+		if options, ok := COMPACTION_OPTIONS[class]; ok {
+			allowed := append(append([]string{"class"}, COMMON_COMPACTION_OPTIONS...), options...)
+			checkOptions(table, "compaction "+class, o.Compaction, allowed)
+		}
+	}
+
+	if o.Compression != nil {
+		checkOptions(table, "compression", o.Compression, COMPRESSION_OPTIONS)
+	}
+
+	if o.Caching != nil {
+		checkOptions(table, "caching", o.Caching, []string{"keys", "rows_per_partition"})
+		if keys, ok := o.Caching["keys"]; ok && keys != "ALL" && keys != "NONE" {
+			log.Fatalf("Table %v caching keys must be ALL or NONE, not %v", table, keys)
+		}
+		if rows, ok := o.Caching["rows_per_partition"]; ok {
+			if _, number := rows.(float64); !number && rows != "ALL" && rows != "NONE" {
+				log.Fatalf("Table %v caching rows_per_partition must be ALL, NONE or a number, not %v", table, rows)
+			}
+		}
+	}
+
+	if strings.Contains(o.Comment, "`") {
+		log.Fatalf("Table %v comment can not hold a backtick, the statements are Go raw strings", table)
+	} else if o.GcGraceSeconds != nil && *o.GcGraceSeconds < 0 {
+		log.Fatalf("Table %v gcGraceSeconds can not be negative", table)
+	} else if o.DefaultTimeToLive != nil && *o.DefaultTimeToLive < 0 {
+		log.Fatalf("Table %v defaultTimeToLive can not be negative", table)
+	} else if o.BloomFilterFpChance != nil && (*o.BloomFilterFpChance <= 0 || *o.BloomFilterFpChance > 1) {
+		log.Fatalf("Table %v bloomFilterFpChance must be above 0 and at most 1", table)
+	}
+}
+
+func checkOptions(table, name string, options map[string]interface{}, allowed []string) {
+	for key := range options {
+		found := false
+		for _, a := range allowed {
+			found = found || key == a
+		}
+		if !found {
+			log.Fatalf("Table %v %v has unknown option %v, expected one of %v", table, name, key, strings.Join(allowed, ", "))
+		} else if strings.Contains(fmt.Sprint(options[key]), "`") {
+			log.Fatalf("Table %v %v option %v can not hold a backtick", table, name, key)
+		}
+	}
+}
+
+// clauses renders the options as the clauses of a WITH ... AND ... table option list.
+func (o *tableOptionsDef) clauses() []string {
+	if o == nil {
+		return nil
+	}
+
+	res := make([]string, 0)
+	if o.Compaction != nil {
+		res = append(res, "compaction = "+cqlMap(o.Compaction))
+	}
+	if o.Compression != nil {
+		res = append(res, "compression = "+cqlMap(o.Compression))
+	}
+	if o.GcGraceSeconds != nil {
+		res = append(res, fmt.Sprintf("gc_grace_seconds = %v", *o.GcGraceSeconds))
+	}
+	if o.Caching != nil {
+		res = append(res, "caching = "+cqlMap(o.Caching))
+	}
+	if o.BloomFilterFpChance != nil {
+		res = append(res, "bloom_filter_fp_chance = "+strconv.FormatFloat(*o.BloomFilterFpChance, 'f', -1, 64))
+	}
+	if o.DefaultTimeToLive != nil {
+		res = append(res, fmt.Sprintf("default_time_to_live = %v", *o.DefaultTimeToLive))
+	}
+	if o.Comment != "" {
+		res = append(res, "comment = "+cqlString(o.Comment))
+	}
+	return res
+}
+
+// cqlMap renders a map literal with its class first, numbers and booleans are left unquoted.
+func cqlMap(options map[string]interface{}) string {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i] == "class" || keys[j] == "class" {
+			return keys[i] == "class"
+		}
+		return keys[i] < keys[j]
+	})
+
+	entries := make([]string, len(keys))
+	for i, key := range keys {
+		value := cqlString(fmt.Sprint(options[key]))
+		switch v := options[key].(type) {
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			value = strconv.FormatBool(v)
+		}
+		entries[i] = fmt.Sprintf("%v: %v", cqlString(key), value)
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+func cqlString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+type keyspaceDef struct {
+	Replication   *replicationDef `json:"replication"`
+	DurableWrites *bool           `json:"durableWrites,omitempty"`
+}
+
+type replicationDef struct {
+	Class             string         `json:"class"`
+	ReplicationFactor int            `json:"replicationFactor,omitempty"`
+	DataCenters       map[string]int `json:"dataCenters,omitempty"`
+}
+
+// validate checks the replication matches its strategy.
+func (k *keyspaceDef) validate(keyspace string) {
+	if k.Replication == nil {
+		log.Fatalf("Keyspace %v must declare its replication", keyspace)
+	}
+
+	switch r := k.Replication; r.Class {
+	case "SimpleStrategy":
+		if r.ReplicationFactor < 1 || len(r.DataCenters) != 0 {
+			log.Fatalf("Keyspace %v uses SimpleStrategy, it needs a replicationFactor and no dataCenters", keyspace)
+		}
+	case "NetworkTopologyStrategy":
+		if r.ReplicationFactor != 0 || len(r.DataCenters) == 0 {
+			log.Fatalf("Keyspace %v uses NetworkTopologyStrategy, it needs the replication factor of each of its datacenters", keyspace)
+		}
+		for dc, factor := range r.DataCenters {
+			if factor < 0 || strings.ContainsAny(dc, "'`") {
+				log.Fatalf("Keyspace %v has an invalid replication factor %v for datacenter %v", keyspace, factor, dc)
+			}
+		}
+	default:
+		log.Fatalf("Keyspace %v has unknown replication class %v, expected SimpleStrategy or NetworkTopologyStrategy", keyspace, r.Class)
+	}
+}
+
+// definition renders the CREATE KEYSPACE statement of keyspace.
+func (k *keyspaceDef) definition(keyspace string) string {
+	replication := []string{fmt.Sprintf("'class': '%v'", k.Replication.Class)}
+	if k.Replication.ReplicationFactor != 0 {
+		replication = append(replication, fmt.Sprintf("'replication_factor': %v", k.Replication.ReplicationFactor))
+	}
+
+	dcs := make([]string, 0, len(k.Replication.DataCenters))
+	for dc := range k.Replication.DataCenters {
+		dcs = append(dcs, dc)
+	}
+	sort.Strings(dcs)
+	for _, dc := range dcs {
+		replication = append(replication, fmt.Sprintf("%v: %v", cqlString(dc), k.Replication.DataCenters[dc]))
+	}
+
+	res := fmt.Sprintf("CREATE KEYSPACE IF NOT EXISTS %v WITH replication = {%v}", keyspace, strings.Join(replication, ", "))
+	if k.DurableWrites != nil {
+		res += fmt.Sprintf(" AND durable_writes = %v", *k.DurableWrites)
+	}
+	return res + ";"
+}